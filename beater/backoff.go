@@ -0,0 +1,47 @@
+package beater
+
+import "time"
+
+// backoff tracks when a failing source may be collected again. The delay
+// doubles with every consecutive failure, up to max.
+type backoff struct {
+	init  time.Duration
+	max   time.Duration
+	delay time.Duration
+	next  time.Time
+}
+
+func newBackoff(init, max time.Duration) *backoff {
+	return &backoff{init: init, max: max}
+}
+
+// Ready reports whether the source may be collected at now.
+func (b *backoff) Ready(now time.Time) bool {
+	return !now.Before(b.next)
+}
+
+// Fail records a failed collection at now and pushes back the next attempt.
+func (b *backoff) Fail(now time.Time) {
+	if b.delay == 0 {
+		b.delay = b.init
+	} else {
+		b.delay *= 2
+	}
+	if b.delay > b.max {
+		b.delay = b.max
+	}
+	b.next = now.Add(b.delay)
+}
+
+// FailMax records a failure which retrying soon will not fix and pushes back
+// the next attempt by the maximum delay.
+func (b *backoff) FailMax(now time.Time) {
+	b.delay = b.max
+	b.next = now.Add(b.delay)
+}
+
+// Reset clears the delay after a successful collection.
+func (b *backoff) Reset() {
+	b.delay = 0
+	b.next = time.Time{}
+}
@@ -0,0 +1,32 @@
+// +build !integration
+
+package beater
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	b := newBackoff(time.Second, 3*time.Second)
+	now := time.Now()
+
+	if !b.Ready(now) {
+		t.Fatal("new backoff should be ready")
+	}
+
+	for _, expected := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		b.Fail(now)
+		if b.Ready(now.Add(expected - time.Millisecond)) {
+			t.Errorf("ready before %v", expected)
+		}
+		if !b.Ready(now.Add(expected)) {
+			t.Errorf("not ready after %v", expected)
+		}
+	}
+
+	b.Reset()
+	if !b.Ready(now) {
+		t.Error("backoff should be ready after reset")
+	}
+}
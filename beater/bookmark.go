@@ -0,0 +1,95 @@
+package beater
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// DatasetBookmark is the type of ZFS bookmarks.
+const DatasetBookmark = "bookmark"
+
+// Bookmark is a ZFS bookmark, the remainder of a snapshot which can still be
+// the source of an incremental send after the snapshot is destroyed.
+//
+// Dataset is the filesystem or volume the bookmark belongs to. The GUID is
+// the one of the snapshot the bookmark was created from, so it matches the
+// GUID of that snapshot on the receiving side.
+type Bookmark struct {
+	Name      string
+	Dataset   string
+	Createtxg uint64
+	Creation  *time.Time
+	GUID      string
+}
+
+var bookmarkPropList = []string{"name", "createtxg", "creation", "guid"}
+
+// StreamBookmarks hands every ZFS bookmark to fn as soon as it is listed.
+// A filter argument may be passed to select the bookmarks of a dataset and
+// its children, or empty string ("") may be used to select all bookmarks.
+// Listing stops at the first error returned by fn.
+func StreamBookmarks(ctx context.Context, e Executor, filter string, fn func(*Bookmark) error) error {
+	args := []string{"list", "-rpH", "-t", DatasetBookmark, "-o", strings.Join(bookmarkPropList, ",")}
+	if filter != "" {
+		args = append(args, filter)
+	}
+
+	return e.Stream(ctx, func(line []string) error {
+		b := &Bookmark{}
+		if err := b.parseLine(line); err != nil {
+			return err
+		}
+		return fn(b)
+	}, "zfs", args...)
+}
+
+// parseLine sets the bookmark from a `zfs list -Hp` output line, whose
+// columns are bookmarkPropList.
+func (b *Bookmark) parseLine(line []string) error {
+	if len(line) != len(bookmarkPropList) {
+		return fmt.Errorf("expected %d properties, got %d in %q", len(bookmarkPropList), len(line), strings.Join(line, "\t"))
+	}
+
+	b.Name = line[0]
+	b.Dataset = strings.SplitN(line[0], "#", 2)[0]
+	if err := setUint(&b.Createtxg, line[1]); err != nil {
+		return err
+	}
+	if err := setOptTime(&b.Creation, line[2]); err != nil {
+		return err
+	}
+	setString(&b.GUID, line[3])
+	return nil
+}
+
+// collectBookmarks publishes one event per ZFS bookmark, pool by pool.
+func (bt *Zfsbeat) collectBookmarks(ctx context.Context, h *host, publish func(beat.Event)) error {
+	return bt.collectPerPool(ctx, h, publish, func(pool string) error {
+		return StreamBookmarks(ctx, h.executor, pool, func(b *Bookmark) error {
+			publish(bookmarkEvent(b))
+			return nil
+		})
+	})
+}
+
+func bookmarkEvent(b *Bookmark) beat.Event {
+	fields := common.MapStr{
+		"source":    DatasetBookmark,
+		"name":      b.Name,
+		"dataset":   b.Dataset,
+		"createtxg": b.Createtxg,
+		"creation":  timeValue(b.Creation),
+		"guid":      b.GUID,
+	}
+	omitNil(fields)
+
+	return beat.Event{
+		Timestamp: time.Now(),
+		Fields:    fields,
+	}
+}
@@ -0,0 +1,39 @@
+// +build !integration
+
+package beater
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStreamBookmarks(t *testing.T) {
+	e := NewFakeExecutor()
+	e.On(FakeResult{Stdout: "tank/home#repl-1\t5123\t1546300800\t1234567890123456789\n" +
+		"tank/vm/disk 1#repl-2\t6001\t1546387200\t987654321\n"},
+		"zfs", "list", "-rpH", "-t", "bookmark", "-o", "name,createtxg,creation,guid", "tank")
+
+	var bookmarks []*Bookmark
+	err := StreamBookmarks(context.Background(), e, "tank", func(b *Bookmark) error {
+		bookmarks = append(bookmarks, b)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bookmarks) != 2 {
+		t.Fatalf("expected 2 bookmarks, got %d", len(bookmarks))
+	}
+	b := bookmarks[0]
+	if b.Name != "tank/home#repl-1" || b.Dataset != "tank/home" || b.Createtxg != 5123 || b.GUID != "1234567890123456789" {
+		t.Errorf("unexpected bookmark: %+v", b)
+	}
+	if !b.Creation.Equal(time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected creation: %v", b.Creation)
+	}
+	if bookmarks[1].Dataset != "tank/vm/disk 1" {
+		t.Errorf("unexpected dataset: %q", bookmarks[1].Dataset)
+	}
+}
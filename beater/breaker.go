@@ -0,0 +1,208 @@
+package beater
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// breaker is the circuit breaker of a pool. It opens after threshold
+// consecutive timeouts of commands scoped to the pool, so a suspended pool
+// doesn't pile up blocked processes, and closes again once a health probe of
+// the pool succeeds.
+//
+// A breaker is updated by the host it belongs to, but the replication source
+// checks the breakers of the target host as well, hence the mutex.
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	timeouts  int
+	open      bool
+}
+
+// Allow reports whether commands scoped to the pool may run.
+func (b *breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.open
+}
+
+// Success records a command of the pool which completed in time.
+func (b *breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.timeouts = 0
+}
+
+// Timeout records a timed out command of the pool and reports whether that
+// opened the breaker.
+func (b *breaker) Timeout() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.timeouts++
+	if b.open || b.timeouts < b.threshold {
+		return false
+	}
+	b.open = true
+	return true
+}
+
+// Trip opens the breaker at once, for a pool which reports its I/O as
+// suspended, and reports whether that opened it.
+func (b *breaker) Trip() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.open {
+		return false
+	}
+	b.open = true
+	return true
+}
+
+// Close closes the breaker after a successful health probe.
+func (b *breaker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.open = false
+	b.timeouts = 0
+}
+
+// state returns whether the breaker is open and the consecutive timeouts.
+func (b *breaker) state() (bool, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.open, b.timeouts
+}
+
+// breaker returns the circuit breaker of a pool of the host.
+func (bt *Zfsbeat) breaker(h *host, pool string) *breaker {
+	h.breakersMu.Lock()
+	defer h.breakersMu.Unlock()
+	if h.breakers == nil {
+		h.breakers = map[string]*breaker{}
+	}
+	b, ok := h.breakers[pool]
+	if !ok {
+		b = &breaker{threshold: bt.config.CircuitBreaker.Threshold}
+		h.breakers[pool] = b
+	}
+	return b
+}
+
+// allowDataset reports whether the pool of a dataset, snapshot or bookmark
+// may be collected, i.e. its circuit breaker is closed. Sources which don't
+// go through collectPerPool check it before running dataset commands.
+func (bt *Zfsbeat) allowDataset(h *host, name string) bool {
+	pool := strings.SplitN(strings.FieldsFunc(name, func(r rune) bool {
+		return r == '@' || r == '#'
+	})[0], "/", 2)[0]
+	if !bt.breaker(h, pool).Allow() {
+		logp.Debug("zfsbeat", "Skipping %s of unresponsive pool %s%s", name, pool, h)
+		return false
+	}
+	return true
+}
+
+// collectPerPool runs list once for every pool of the host whose circuit
+// breaker is closed. A timeout counts against the breaker of the pool, and a
+// pool_unresponsive event is published when it opens. A pool whose I/O is
+// suspended, with failmode=continue, fails at once instead of timing out, so
+// it opens the breaker right away with a pool_suspended event. The first
+// error is returned once all pools were tried.
+func (bt *Zfsbeat) collectPerPool(ctx context.Context, h *host, publish func(beat.Event), list func(pool string) error) error {
+	pools, err := zpoolNames(ctx, h.executor)
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+	for _, pool := range pools {
+		b := bt.breaker(h, pool)
+		if !b.Allow() {
+			logp.Debug("zfsbeat", "Skipping unresponsive pool %s%s", pool, h)
+			continue
+		}
+
+		err := list(pool)
+		switch {
+		case err == nil:
+			b.Success()
+		case errors.Is(err, ErrTimeout):
+			if b.Timeout() {
+				logp.Warn("Pool %s%s is unresponsive after %d timeouts, skipping it until it recovers", pool, h, bt.config.CircuitBreaker.Threshold)
+				publish(poolBreakerEvent("pool_unresponsive", pool, b))
+			}
+		case errors.Is(err, ErrPoolSuspended):
+			if b.Trip() {
+				logp.Critical("Pool %s%s is suspended, skipping it until it recovers: %v", pool, h, err)
+				publish(poolBreakerEvent("pool_suspended", pool, b))
+			}
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// probePools runs a cheap health probe of every pool of the host whose
+// circuit breaker is open, and closes the breaker when the pool answers and
+// isn't suspended anymore.
+func (bt *Zfsbeat) probePools(h *host) {
+	h.breakersMu.Lock()
+	breakers := make(map[string]*breaker, len(h.breakers))
+	for pool, b := range h.breakers {
+		breakers[pool] = b
+	}
+	h.breakersMu.Unlock()
+
+	for pool, b := range breakers {
+		if b.Allow() {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(bt.ctx, bt.config.CircuitBreaker.ProbeTimeout)
+		out, err := zpool(ctx, h.executor, "list", "-H", "-o", "health", pool)
+		cancel()
+
+		switch {
+		case errors.Is(err, ErrNotExist):
+			logp.Info("Unresponsive pool %s%s was exported or destroyed", pool, h)
+			h.breakersMu.Lock()
+			delete(h.breakers, pool)
+			h.breakersMu.Unlock()
+		case err != nil:
+			logp.Debug("zfsbeat", "Probe of unresponsive pool %s%s failed: %v", pool, h, err)
+		case len(out) > 0 && len(out[0]) > 0 && out[0][0] != ZpoolSuspended:
+			logp.Info("Pool %s%s recovered with health %s", pool, h, out[0][0])
+			b.Close()
+			bt.publish(h, poolBreakerEvent("pool_recovered", pool, b))
+		}
+	}
+}
+
+func poolBreakerEvent(source, pool string, b *breaker) beat.Event {
+	open, timeouts := b.state()
+	state := "closed"
+	if open {
+		state = "open"
+	}
+	return beat.Event{
+		Timestamp: time.Now(),
+		Fields: common.MapStr{
+			"source":           source,
+			"name":             pool,
+			"circuit.state":    state,
+			"circuit.timeouts": timeouts,
+		},
+	}
+}
@@ -0,0 +1,92 @@
+// +build !integration
+
+package beater
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/beat"
+
+	"github.com/maireanu/zfsbeat/config"
+)
+
+func TestBreaker(t *testing.T) {
+	b := &breaker{threshold: 2}
+
+	if b.Timeout() || !b.Allow() {
+		t.Fatal("breaker opened before the threshold")
+	}
+	b.Success()
+	if b.Timeout() || !b.Allow() {
+		t.Fatal("a success should reset the timeouts")
+	}
+	if !b.Timeout() || b.Allow() {
+		t.Fatal("breaker should open at the threshold")
+	}
+	if b.Timeout() {
+		t.Error("an open breaker should not report opening again")
+	}
+
+	b.Close()
+	if !b.Allow() || b.timeouts != 0 {
+		t.Error("breaker should allow commands after closing")
+	}
+}
+
+func TestBreakerSkipsDatasetSources(t *testing.T) {
+	e := NewFakeExecutor()
+
+	c := config.DefaultConfig
+	c.CircuitBreaker.Threshold = 1
+	c.Space.Datasets = []string{"tank/home"}
+	c.Diff.Datasets = []string{"tank/home"}
+	bt := newLocalZfsbeat(c, e)
+	h := bt.hosts[0]
+	bt.breaker(h, "tank").Timeout()
+
+	publish := func(beat.Event) {}
+	if err := bt.collectSpace(context.Background(), h, publish); err != nil {
+		t.Fatal(err)
+	}
+	if err := bt.collectDiffs(context.Background(), h, publish); err != nil {
+		t.Fatal(err)
+	}
+	if err := bt.collectHolds(context.Background(), h, publish, []string{"tank/home@daily"}); err != nil {
+		t.Fatal(err)
+	}
+	if calls := e.Calls(); len(calls) != 0 {
+		t.Errorf("expected no commands for the unresponsive pool, got %v", calls)
+	}
+	if !bt.allowDataset(h, "backup/home@daily") {
+		t.Error("expected other pools to be allowed")
+	}
+}
+
+func TestCollectPerPoolSuspended(t *testing.T) {
+	e := NewFakeExecutor()
+	e.On(FakeResult{Stdout: "tank\n"}, "zpool", "list", "-H", "-o", "name")
+
+	bt := newLocalZfsbeat(config.DefaultConfig, e)
+	h := bt.hosts[0]
+
+	var events []beat.Event
+	publish := func(event beat.Event) { events = append(events, event) }
+	suspended := newError(fmt.Errorf("exit status 1"), 1, "zfs list", "cannot open 'tank': pool I/O is currently suspended\n")
+	for i := 0; i < 2; i++ {
+		bt.collectPerPool(context.Background(), h, publish, func(pool string) error {
+			return suspended
+		})
+	}
+
+	if bt.breaker(h, "tank").Allow() {
+		t.Error("expected a suspended pool to open the breaker at once")
+	}
+	if len(events) != 1 || events[0].Fields["source"] != "pool_suspended" {
+		t.Errorf("expected a single pool_suspended event, got %v", events)
+	}
+	if remedy(suspended) == "" {
+		t.Error("expected a remedy for a suspended pool")
+	}
+}
@@ -0,0 +1,320 @@
+package beater
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// Change types printed by `zfs diff`.
+const (
+	ChangeAdded    = "+"
+	ChangeRemoved  = "-"
+	ChangeModified = "M"
+	ChangeRenamed  = "R"
+)
+
+var changeNames = map[string]string{
+	ChangeAdded:    "added",
+	ChangeRemoved:  "removed",
+	ChangeModified: "modified",
+	ChangeRenamed:  "renamed",
+}
+
+// fileTypeNames are the names of the file types printed by `zfs diff -F`.
+var fileTypeNames = map[string]string{
+	"F": "file",
+	"/": "directory",
+	"@": "symlink",
+	"B": "block_device",
+	"C": "char_device",
+	"=": "socket",
+	"|": "fifo",
+	">": "door",
+	"P": "portal",
+}
+
+// Change is a single file change between two snapshots. NewPath is only set
+// for renames.
+type Change struct {
+	Type     string
+	FileType string
+	Path     string
+	NewPath  string
+}
+
+// StreamDiff hands every change between the snapshot from and the snapshot or
+// filesystem to, as listed by `zfs diff -FHt`, to fn.
+func StreamDiff(ctx context.Context, e Executor, from, to string, fn func(*Change) error) error {
+	return e.Stream(ctx, func(line []string) error {
+		c := &Change{}
+		if err := c.parseLine(line); err != nil {
+			return err
+		}
+		return fn(c)
+	}, "zfs", "diff", "-FHt", from, to)
+}
+
+// parseLine sets the change from a `zfs diff -FHt` output line: the time of
+// the change, the change type, the file type, the path and, for renames, the
+// new path. The paths are unescaped.
+func (c *Change) parseLine(line []string) error {
+	if len(line) != 4 && len(line) != 5 {
+		return fmt.Errorf("expected 4 or 5 columns, got %d in %q", len(line), strings.Join(line, "\t"))
+	}
+
+	c.Type = line[1]
+	c.FileType = line[2]
+	c.Path = unescapeDiffPath(line[3])
+	if len(line) == 5 {
+		c.NewPath = unescapeDiffPath(line[4])
+	}
+	return nil
+}
+
+// unescapeDiffPath decodes the escapes zfs diff prints for whitespace and
+// non-printable bytes in paths: a backslash, a zero and the octal value of
+// the byte, such as \0040 for a space.
+func unescapeDiffPath(p string) string {
+	if !strings.Contains(p, "\\") {
+		return p
+	}
+
+	b := make([]byte, 0, len(p))
+	for i := 0; i < len(p); i++ {
+		if p[i] == '\\' && i+4 < len(p) && p[i+1] == '0' {
+			if v, err := strconv.ParseUint(p[i+2:i+5], 8, 8); err == nil {
+				b = append(b, byte(v))
+				i += 4
+				continue
+			}
+		}
+		b = append(b, p[i])
+	}
+	return string(b)
+}
+
+// diffDirs is the number of directories a DiffSummary counts the changes of.
+// A mass modification touches far more directories, so only the most changed
+// ones are kept.
+const diffDirs = 1024
+
+// DiffSummary counts the changes between two snapshots by file type and
+// change type, and by the directory they happened in.
+type DiffSummary struct {
+	From  string
+	To    string
+	Total map[string]int
+	Files map[string]map[string]int
+	dirs  *topCounter
+}
+
+func newDiffSummary(from, to string) *DiffSummary {
+	return &DiffSummary{
+		From:  from,
+		To:    to,
+		Total: map[string]int{},
+		Files: map[string]map[string]int{},
+		dirs:  newTopCounter(diffDirs),
+	}
+}
+
+// Add counts a change.
+func (s *DiffSummary) Add(c *Change) {
+	change := changeNames[c.Type]
+	if change == "" {
+		change = c.Type
+	}
+	fileType := fileTypeNames[c.FileType]
+	if fileType == "" {
+		fileType = c.FileType
+	}
+
+	s.Total[change]++
+	if s.Files[fileType] == nil {
+		s.Files[fileType] = map[string]int{}
+	}
+	s.Files[fileType][change]++
+	s.dirs.Add(path.Dir(c.Path))
+}
+
+// TopDirs returns the n directories with the most changes, most changed
+// first, along with their change counts. Once more than diffDirs directories
+// changed, the counts are upper bounds.
+func (s *DiffSummary) TopDirs(n int) []topEntry {
+	return s.dirs.Top(n)
+}
+
+// topCounter counts keys in bounded memory with the Space-Saving algorithm:
+// once it holds capacity keys, a new key replaces the least counted one and
+// takes over its count. The most frequent keys are kept, and their counts
+// are exact as long as no key was ever replaced. The entries form a min-heap
+// by count.
+type topCounter struct {
+	capacity int
+	entries  []topEntry
+	index    map[string]int
+}
+
+type topEntry struct {
+	Key   string
+	Count int
+}
+
+func newTopCounter(capacity int) *topCounter {
+	return &topCounter{capacity: capacity, index: map[string]int{}}
+}
+
+func (c *topCounter) Len() int           { return len(c.entries) }
+func (c *topCounter) Less(i, j int) bool { return c.entries[i].Count < c.entries[j].Count }
+
+func (c *topCounter) Swap(i, j int) {
+	c.entries[i], c.entries[j] = c.entries[j], c.entries[i]
+	c.index[c.entries[i].Key] = i
+	c.index[c.entries[j].Key] = j
+}
+
+func (c *topCounter) Push(x interface{}) {
+	e := x.(topEntry)
+	c.index[e.Key] = len(c.entries)
+	c.entries = append(c.entries, e)
+}
+
+func (c *topCounter) Pop() interface{} {
+	e := c.entries[len(c.entries)-1]
+	c.entries = c.entries[:len(c.entries)-1]
+	delete(c.index, e.Key)
+	return e
+}
+
+// Add counts an occurrence of key.
+func (c *topCounter) Add(key string) {
+	if i, ok := c.index[key]; ok {
+		c.entries[i].Count++
+		heap.Fix(c, i)
+		return
+	}
+	if len(c.entries) < c.capacity {
+		heap.Push(c, topEntry{Key: key, Count: 1})
+		return
+	}
+	delete(c.index, c.entries[0].Key)
+	c.entries[0] = topEntry{Key: key, Count: c.entries[0].Count + 1}
+	c.index[key] = 0
+	heap.Fix(c, 0)
+}
+
+// Top returns the n most counted keys, most counted first.
+func (c *topCounter) Top(n int) []topEntry {
+	top := append([]topEntry(nil), c.entries...)
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Key < top[j].Key
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// collectDiffs publishes a summary of the changes between the two newest
+// snapshots of every configured dataset, once for every new snapshot.
+func (bt *Zfsbeat) collectDiffs(ctx context.Context, h *host, publish func(beat.Event)) error {
+	for _, name := range bt.config.Diff.Datasets {
+		if !bt.allowDataset(h, name) {
+			continue
+		}
+		if err := bt.collectDiff(ctx, h, publish, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (bt *Zfsbeat) collectDiff(ctx context.Context, h *host, publish func(beat.Event), name string) error {
+	from, to, err := newestSnapshots(ctx, h.executor, name)
+	if err != nil || from == "" {
+		return err
+	}
+	if h.diffed == nil {
+		h.diffed = map[string]string{}
+	}
+	if h.diffed[name] == to {
+		return nil
+	}
+
+	s := newDiffSummary(from, to)
+	err = StreamDiff(ctx, h.executor, from, to, func(c *Change) error {
+		s.Add(c)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	publish(diffEvent(name, s, bt.config.Diff.TopPaths))
+	h.diffed[name] = to
+	return nil
+}
+
+// newestSnapshots returns the two newest snapshots of a dataset, not those of
+// its children, oldest first. They are empty when the dataset has fewer than
+// two snapshots. Only the names are listed, in creation txg order, which also
+// orders the snapshots taken within the same second.
+func newestSnapshots(ctx context.Context, e Executor, name string) (string, string, error) {
+	var previous, newest string
+	err := e.Stream(ctx, func(line []string) error {
+		previous, newest = newest, line[0]
+		return nil
+	}, "zfs", "list", "-Hp", "-d", "1", "-t", "snapshot", "-o", "name", "-s", "createtxg", name)
+	if err != nil || previous == "" {
+		return "", "", err
+	}
+	return previous, newest, nil
+}
+
+func diffEvent(dataset string, s *DiffSummary, topPaths int) beat.Event {
+	files := common.MapStr{}
+	for fileType, changes := range s.Files {
+		counts := common.MapStr{}
+		for change, n := range changes {
+			counts[change] = n
+		}
+		files[fileType] = counts
+	}
+
+	var top []common.MapStr
+	for _, dir := range s.TopDirs(topPaths) {
+		top = append(top, common.MapStr{"path": dir.Key, "changes": dir.Count})
+	}
+
+	fields := common.MapStr{
+		"source":           "diff",
+		"dataset":          dataset,
+		"from":             s.From,
+		"to":               s.To,
+		"changes.added":    s.Total["added"],
+		"changes.removed":  s.Total["removed"],
+		"changes.modified": s.Total["modified"],
+		"changes.renamed":  s.Total["renamed"],
+		"files":            files,
+	}
+	if len(top) > 0 {
+		fields["top_paths"] = top
+	}
+
+	return beat.Event{
+		Timestamp: time.Now(),
+		Fields:    fields,
+	}
+}
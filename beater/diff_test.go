@@ -0,0 +1,83 @@
+// +build !integration
+
+package beater
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/common"
+
+	"github.com/maireanu/zfsbeat/config"
+)
+
+func TestCollectDiffs(t *testing.T) {
+	e := NewFakeExecutor()
+	e.On(FakeResult{Stdout: "tank/home@a\ntank/home@b\n"},
+		"zfs", "list", "-Hp", "-d", "1", "-t", "snapshot", "-o", "name", "-s", "createtxg", "tank/home")
+	e.On(FakeResult{Stdout: "1546301000.1\tM\t/\t/tank/home/docs\n" +
+		"1546301000.2\t+\tF\t/tank/home/docs/a.txt.locked\n" +
+		"1546301000.3\t-\tF\t/tank/home/docs/a.txt\n" +
+		"1546301000.4\tR\tF\t/tank/home/b.txt\t/tank/home/docs/b.txt\n" +
+		"1546301000.5\t+\t@\t/tank/home/link\n"},
+		"zfs", "diff", "-FHt", "tank/home@a", "tank/home@b")
+
+	c := config.DefaultConfig
+	c.Diff = config.Diff{Datasets: []string{"tank/home"}, TopPaths: 1}
+	bt := newLocalZfsbeat(c, e)
+
+	var events []beat.Event
+	publish := func(event beat.Event) { events = append(events, event) }
+	for i := 0; i < 2; i++ {
+		if err := bt.collectDiffs(context.Background(), bt.hosts[0], publish); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(events) != 1 {
+		t.Fatalf("expected a single event for the new snapshot, got %d", len(events))
+	}
+	fields := events[0].Fields
+	if fields["from"] != "tank/home@a" || fields["to"] != "tank/home@b" {
+		t.Errorf("unexpected snapshots: %v", fields)
+	}
+	if fields["changes.added"] != 2 || fields["changes.removed"] != 1 || fields["changes.modified"] != 1 || fields["changes.renamed"] != 1 {
+		t.Errorf("unexpected changes: %v", fields)
+	}
+	if v, _ := fields.GetValue("files.file.added"); v != 1 {
+		t.Errorf("unexpected file changes: %v", fields["files"])
+	}
+	top := fields["top_paths"].([]common.MapStr)
+	if len(top) != 1 || top[0]["path"] != "/tank/home" || top[0]["changes"] != 3 {
+		t.Errorf("unexpected top paths: %v", top)
+	}
+}
+
+func TestDiffPathEscapes(t *testing.T) {
+	c := &Change{}
+	if err := c.parseLine([]string{"1546301000.1", "R", "F", `/tank/home/my\0040file`, `/tank/home/caf\0303\0251`}); err != nil {
+		t.Fatal(err)
+	}
+	if c.Path != "/tank/home/my file" || c.NewPath != "/tank/home/café" {
+		t.Errorf("unexpected paths: %q %q", c.Path, c.NewPath)
+	}
+}
+
+func TestTopCounter(t *testing.T) {
+	c := newTopCounter(2)
+	for _, key := range []string{"/a", "/a", "/a", "/b", "/c", "/a"} {
+		c.Add(key)
+	}
+
+	// /c replaced /b and took over its count.
+	top := c.Top(3)
+	expected := []topEntry{{Key: "/a", Count: 4}, {Key: "/c", Count: 2}}
+	if !reflect.DeepEqual(top, expected) {
+		t.Errorf("expected %v, got %v", expected, top)
+	}
+	if _, ok := c.index["/b"]; ok || len(c.index) != 2 {
+		t.Errorf("unexpected index: %v", c.index)
+	}
+}
@@ -1,18 +1,134 @@
 package beater
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"os/exec"
+	"strings"
 )
 
+// ErrorType classifies why a `zfs` or `zpool` command failed. The types can be
+// matched against an Error with errors.Is.
+type ErrorType string
+
+// Error types recognized from the command error and its stderr.
+const (
+	ErrBinaryNotFound      ErrorType = "binary_not_found"
+	ErrPermissionDenied    ErrorType = "permission_denied"
+	ErrNotExist            ErrorType = "not_exist"
+	ErrUnsupportedProperty ErrorType = "unsupported_property"
+	ErrPoolSuspended       ErrorType = "pool_suspended"
+	ErrTimeout             ErrorType = "timeout"
+	ErrHostUnreachable     ErrorType = "host_unreachable"
+)
+
+// Error returns the string representation of an ErrorType.
+func (t ErrorType) Error() string {
+	return strings.Replace(string(t), "_", " ", -1)
+}
+
 // Error is an error which is returned when the `zfs` or `zpool` shell
 // commands return with a non-zero exit code.
 type Error struct {
-	Err    error
-	Debug  string
-	Stderr string
+	Err      error
+	Debug    string
+	Stderr   string
+	ExitCode int
+	Type     ErrorType
+}
+
+// newError builds an Error and classifies it by its cause and stderr.
+func newError(err error, exitCode int, debug, stderr string) *Error {
+	return &Error{
+		Err:      err,
+		Debug:    debug,
+		Stderr:   stderr,
+		ExitCode: exitCode,
+		Type:     classify(err, exitCode, stderr),
+	}
 }
 
 // Error returns the string representation of an Error.
 func (e Error) Error() string {
 	return fmt.Sprintf("%s: %q => %s", e.Err, e.Debug, e.Stderr)
 }
+
+// Unwrap returns the underlying error of the command.
+func (e Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the Error is of the given ErrorType.
+func (e Error) Is(target error) bool {
+	return e.Type != "" && target == e.Type
+}
+
+// Timeout reports whether the command was killed because it ran longer than
+// the command timeout.
+func (e Error) Timeout() bool {
+	return e.Type == ErrTimeout
+}
+
+func classify(err error, exitCode int, stderr string) ErrorType {
+	msg := strings.ToLower(stderr)
+
+	switch {
+	case err == context.DeadlineExceeded:
+		return ErrTimeout
+	case strings.HasPrefix(msg, "ssh:"),
+		strings.Contains(msg, "connection refused"),
+		strings.Contains(msg, "connection timed out"),
+		strings.Contains(msg, "no route to host"),
+		strings.Contains(msg, "could not resolve hostname"),
+		strings.Contains(msg, "host key verification failed"):
+		return ErrHostUnreachable
+	case errors.Is(err, exec.ErrNotFound), errors.Is(err, os.ErrNotExist), exitCode == 127,
+		strings.Contains(msg, "command not found"),
+		wrapperNotFound(msg):
+		return ErrBinaryNotFound
+	case strings.Contains(msg, "permission denied"),
+		strings.Contains(msg, "operation not permitted"),
+		strings.Contains(msg, "must be root"),
+		strings.Contains(msg, "a password is required"),
+		strings.Contains(msg, "authorization required"):
+		return ErrPermissionDenied
+	case strings.Contains(msg, "i/o is currently suspended"):
+		return ErrPoolSuspended
+	case strings.Contains(msg, "invalid property"),
+		strings.Contains(msg, "bad property list"):
+		return ErrUnsupportedProperty
+	case strings.Contains(msg, "does not exist"),
+		strings.Contains(msg, "no such pool"),
+		strings.Contains(msg, "no such dataset"):
+		return ErrNotExist
+	}
+	return ""
+}
+
+// wrapperNotFound reports whether stderr comes from a command prefix which
+// could not execute the binary. zfs itself prints "No such file or directory"
+// about missing objects, e.g. in zfs diff, which says nothing about the
+// binary.
+func wrapperNotFound(msg string) bool {
+	if !strings.Contains(msg, "no such file or directory") {
+		return false
+	}
+	for _, prefix := range []string{"sudo:", "doas:", "env:", "nsenter: failed to execute"} {
+		if strings.HasPrefix(msg, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// exitCode returns the exit code of a command which failed with err, or -1
+// if the command did not exit on its own.
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
@@ -0,0 +1,55 @@
+// +build !integration
+
+package beater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+)
+
+func TestErrorClassification(t *testing.T) {
+	exit1 := fmt.Errorf("exit status 1")
+
+	cases := []struct {
+		err      error
+		exitCode int
+		stderr   string
+		expected ErrorType
+	}{
+		{exec.ErrNotFound, -1, "", ErrBinaryNotFound},
+		{exit1, 1, "nsenter: failed to execute zfs: No such file or directory\n", ErrBinaryNotFound},
+		{exit1, 1, "sudo: /sbin/zfs: command not found\n", ErrBinaryNotFound},
+		{exit1, 1, "Unable to determine path or stats for object 2 in tank/home@a: No such file or directory\n", ""},
+		{exit1, 1, "cannot open 'tank/secret': permission denied\n", ErrPermissionDenied},
+		{exit1, 1, "cannot open 'tank/gone': dataset does not exist\n", ErrNotExist},
+		{exit1, 1, "cannot open 'gone': no such pool\n", ErrNotExist},
+		{exit1, 2, "bad property list: invalid property 'overlay'\n", ErrUnsupportedProperty},
+		{exit1, 1, "cannot open 'tank': pool I/O is currently suspended\n", ErrPoolSuspended},
+		{context.DeadlineExceeded, -1, "", ErrTimeout},
+		{exit1, 1, "internal error: Invalid argument\n", ""},
+	}
+
+	for _, c := range cases {
+		var err error = newError(c.err, c.exitCode, "zfs list", c.stderr)
+
+		var zerr *Error
+		if !errors.As(err, &zerr) {
+			t.Fatalf("expected *Error, got %T", err)
+		}
+		if zerr.Type != c.expected {
+			t.Errorf("%q: expected type %q, got %q", c.stderr, c.expected, zerr.Type)
+		}
+		if zerr.ExitCode != c.exitCode {
+			t.Errorf("%q: expected exit code %d, got %d", c.stderr, c.exitCode, zerr.ExitCode)
+		}
+		if c.expected != "" && !errors.Is(err, c.expected) {
+			t.Errorf("%q: errors.Is does not match %q", c.stderr, c.expected)
+		}
+		if !errors.Is(err, c.err) {
+			t.Errorf("%q: errors.Is does not match the underlying error", c.stderr)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package beater
+
+import (
+	"context"
+	"time"
+
+	"github.com/maireanu/zfsbeat/config"
+)
+
+// Executor runs a `zfs` or `zpool` command and returns its output, one slice
+// of whitespace separated fields per line. The command is aborted when ctx is
+// done.
+//
+// Stream hands every line to fn as soon as it is read instead of buffering
+// the whole output. The command is aborted when fn returns an error.
+type Executor interface {
+	Run(ctx context.Context, name string, arg ...string) ([][]string, error)
+	Stream(ctx context.Context, fn func(fields []string) error, name string, arg ...string) error
+}
+
+// NewExecutor returns an Executor which runs the commands on the local host,
+// using the binary paths and command prefix from the config. Every command is
+// killed after the command timeout, unless it is zero.
+func NewExecutor(c config.Config) Executor {
+	return execExecutor{
+		timeout: c.CommandTimeout,
+		prefix:  c.CommandPrefix,
+		paths: map[string]string{
+			"zfs":   c.ZfsPath,
+			"zpool": c.ZpoolPath,
+		},
+	}
+}
+
+type execExecutor struct {
+	timeout time.Duration
+	prefix  []string
+	paths   map[string]string
+	ssh     []string
+}
+
+func (e execExecutor) Run(ctx context.Context, name string, arg ...string) ([][]string, error) {
+	ctx, cancel := e.withTimeout(ctx)
+	defer cancel()
+
+	c, arg := e.command(name, arg)
+	return c.Run(ctx, arg...)
+}
+
+func (e execExecutor) Stream(ctx context.Context, fn func(fields []string) error, name string, arg ...string) error {
+	ctx, cancel := e.withTimeout(ctx)
+	defer cancel()
+
+	c, arg := e.command(name, arg)
+	return c.Stream(ctx, fn, arg...)
+}
+
+// command resolves the configured path of the named binary and puts the
+// command prefix, such as `sudo -n`, in front of it. Only zfs and zpool get
+// the prefix, other commands such as readlink need no privileges. For remote
+// hosts the whole command line is run through ssh.
+func (e execExecutor) command(name string, arg []string) (*command, []string) {
+	var prefix []string
+	if path, ok := e.paths[name]; ok {
+		prefix = e.prefix
+		if path != "" {
+			name = path
+		}
+	}
+	if len(prefix) == 0 && len(e.ssh) == 0 {
+		return &command{Command: name}, arg
+	}
+
+	args := make([]string, 0, len(e.ssh)+len(prefix)+len(arg)+1)
+	args = append(args, prefix...)
+	args = append(args, name)
+	args = append(args, arg...)
+	if len(e.ssh) == 0 {
+		return &command{Command: args[0]}, args[1:]
+	}
+
+	// ssh hands the remote command line to a shell.
+	for i, a := range args {
+		args[i] = shellQuote(a)
+	}
+	return &command{Command: e.ssh[0]}, append(e.ssh[1:len(e.ssh):len(e.ssh)], args...)
+}
+
+func (e execExecutor) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if e.timeout > 0 {
+		return context.WithTimeout(ctx, e.timeout)
+	}
+	return context.WithCancel(ctx)
+}
@@ -0,0 +1,34 @@
+// +build !integration
+
+package beater
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/maireanu/zfsbeat/config"
+)
+
+func TestExecutorCommand(t *testing.T) {
+	c := config.DefaultConfig
+	c.ZfsPath = "/sbin/zfs"
+
+	e := NewExecutor(c).(execExecutor)
+	cmd, args := e.command("zfs", []string{"list", "-H"})
+	if cmd.Command != "/sbin/zfs" || !reflect.DeepEqual(args, []string{"list", "-H"}) {
+		t.Errorf("unexpected command: %s %v", cmd.Command, args)
+	}
+
+	c.CommandPrefix = []string{"nsenter", "-t", "1", "-m", "--"}
+	e = NewExecutor(c).(execExecutor)
+	cmd, args = e.command("zpool", []string{"get", "-Hp", "all"})
+	expected := []string{"-t", "1", "-m", "--", "zpool", "get", "-Hp", "all"}
+	if cmd.Command != "nsenter" || !reflect.DeepEqual(args, expected) {
+		t.Errorf("unexpected command: %s %v", cmd.Command, args)
+	}
+
+	cmd, args = e.command("readlink", []string{"-m", "--", "/dev/zvol/tank/vm1"})
+	if cmd.Command != "readlink" || !reflect.DeepEqual(args, []string{"-m", "--", "/dev/zvol/tank/vm1"}) {
+		t.Errorf("expected no prefix for readlink: %s %v", cmd.Command, args)
+	}
+}
@@ -0,0 +1,94 @@
+package beater
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeResult is the canned outcome of a single command run by FakeExecutor.
+// Delay makes the command block for that long, or until its context is done.
+type FakeResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Delay    time.Duration
+}
+
+// FakeExecutor is an Executor which replays canned results instead of running
+// the `zfs` and `zpool` binaries. It is meant to be used in tests.
+type FakeExecutor struct {
+	mu      sync.Mutex
+	results map[string]FakeResult
+	calls   []string
+}
+
+// NewFakeExecutor returns a FakeExecutor without any registered results.
+func NewFakeExecutor() *FakeExecutor {
+	return &FakeExecutor{results: map[string]FakeResult{}}
+}
+
+// On registers the result returned when the given command line is run.
+func (f *FakeExecutor) On(result FakeResult, name string, arg ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.results[commandLine(name, arg...)] = result
+}
+
+// Calls returns the command lines run so far, in order.
+func (f *FakeExecutor) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+// Run replays the result registered for the command line. Commands without a
+// registered result fail as if the binary exited with code 127.
+func (f *FakeExecutor) Run(ctx context.Context, name string, arg ...string) ([][]string, error) {
+	var output [][]string
+	err := f.Stream(ctx, func(fields []string) error {
+		output = append(output, fields)
+		return nil
+	}, name, arg...)
+	if err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// Stream replays the result registered for the command line like Run, handing
+// the stdout lines to fn before reporting a non-zero exit code.
+func (f *FakeExecutor) Stream(ctx context.Context, fn func(fields []string) error, name string, arg ...string) error {
+	line := commandLine(name, arg...)
+
+	f.mu.Lock()
+	f.calls = append(f.calls, line)
+	result, ok := f.results[line]
+	f.mu.Unlock()
+
+	if !ok {
+		result = FakeResult{Stderr: "fake: no result registered", ExitCode: 127}
+	}
+	if result.Delay > 0 {
+		select {
+		case <-time.After(result.Delay):
+		case <-ctx.Done():
+			return newError(ctx.Err(), -1, line, "")
+		}
+	}
+	for _, fields := range splitOutput(result.Stdout) {
+		if err := fn(fields); err != nil {
+			return err
+		}
+	}
+	if result.ExitCode != 0 {
+		return newError(fmt.Errorf("exit status %d", result.ExitCode), result.ExitCode, line, result.Stderr)
+	}
+	return nil
+}
+
+func commandLine(name string, arg ...string) string {
+	return strings.Join(append([]string{name}, arg...), " ")
+}
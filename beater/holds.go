@@ -0,0 +1,100 @@
+package beater
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// Hold is a user hold on a snapshot, which prevents it from being destroyed.
+type Hold struct {
+	Snapshot  string
+	Tag       string
+	Timestamp *time.Time
+}
+
+// holdsBatchSize is the number of snapshots passed to a single `zfs holds`,
+// to keep the command line short.
+const holdsBatchSize = 100
+
+// StreamHolds hands every hold of the given snapshots to fn. Listing stops
+// at the first error returned by fn.
+func StreamHolds(ctx context.Context, e Executor, snapshots []string, fn func(*Hold) error) error {
+	for len(snapshots) > 0 {
+		n := len(snapshots)
+		if n > holdsBatchSize {
+			n = holdsBatchSize
+		}
+
+		args := append([]string{"holds", "-Hp"}, snapshots[:n]...)
+		err := e.Stream(ctx, func(line []string) error {
+			h := &Hold{}
+			if err := h.parseLine(line); err != nil {
+				return err
+			}
+			return fn(h)
+		}, "zfs", args...)
+		if err != nil {
+			return err
+		}
+		snapshots = snapshots[n:]
+	}
+	return nil
+}
+
+// parseLine sets the hold from a `zfs holds -Hp` output line: the snapshot,
+// the tag and the time the hold was placed, in seconds since the epoch.
+func (h *Hold) parseLine(line []string) error {
+	if len(line) != 3 {
+		return fmt.Errorf("expected 3 columns, got %d in %q", len(line), strings.Join(line, "\t"))
+	}
+
+	h.Snapshot = line[0]
+	h.Tag = line[1]
+	return setOptTime(&h.Timestamp, line[2])
+}
+
+// collectHolds publishes one event per hold of the given snapshots.
+func (bt *Zfsbeat) collectHolds(ctx context.Context, h *host, publish func(beat.Event), snapshots []string) error {
+	var allowed []string
+	for _, snapshot := range snapshots {
+		if bt.allowDataset(h, snapshot) {
+			allowed = append(allowed, snapshot)
+		}
+	}
+
+	now := time.Now()
+	return StreamHolds(ctx, h.executor, allowed, func(hold *Hold) error {
+		publish(holdEvent(hold, now, bt.config.Holds.MaxAge))
+		return nil
+	})
+}
+
+// holdEvent builds the event of a hold. The hold is flagged as stale when it
+// is older than maxAge, unless maxAge is zero.
+func holdEvent(h *Hold, now time.Time, maxAge time.Duration) beat.Event {
+	fields := common.MapStr{
+		"source":    "hold",
+		"name":      h.Snapshot,
+		"dataset":   strings.SplitN(h.Snapshot, "@", 2)[0],
+		"tag":       h.Tag,
+		"timestamp": timeValue(h.Timestamp),
+	}
+	if h.Timestamp != nil {
+		age := now.Sub(*h.Timestamp)
+		fields["age_sec"] = int64(age / time.Second)
+		if maxAge > 0 {
+			fields["stale"] = age > maxAge
+		}
+	}
+	omitNil(fields)
+
+	return beat.Event{
+		Timestamp: now,
+		Fields:    fields,
+	}
+}
@@ -0,0 +1,54 @@
+// +build !integration
+
+package beater
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/beat"
+
+	"github.com/maireanu/zfsbeat/config"
+)
+
+func TestCollectHolds(t *testing.T) {
+	e := NewFakeExecutor()
+	e.On(FakeResult{Stdout: "tank\n"}, "zpool", "list", "-H", "-o", "name")
+	e.On(FakeResult{Stdout: "tank@old\t1546300800\t0\t0\t1\n" +
+		"tank@free\t1546300800\t0\t0\t0\n" +
+		"tank/home@new\t1546300800\t0\t0\t1\n"},
+		"zfs", "list", "-rpH", "-t", "snapshot", "-o", strings.Join(rollupPropList, ","), "tank")
+
+	now := time.Now().Unix()
+	e.On(FakeResult{Stdout: fmt.Sprintf("tank@old\tbackup\t%d\n", now-30*24*3600) +
+		fmt.Sprintf("tank/home@new\tsend\t%d\n", now-60)},
+		"zfs", "holds", "-Hp", "tank@old", "tank/home@new")
+
+	c := config.DefaultConfig
+	c.Holds = config.Holds{Enabled: true, MaxAge: 7 * 24 * time.Hour}
+	bt := newLocalZfsbeat(c, e)
+
+	var holds []beat.Event
+	err := bt.collectSnapshots(context.Background(), bt.hosts[0], func(event beat.Event) {
+		if event.Fields["source"] == "hold" {
+			holds = append(holds, event)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(holds) != 2 {
+		t.Fatalf("expected 2 holds, got %d", len(holds))
+	}
+	old, recent := holds[0].Fields, holds[1].Fields
+	if old["name"] != "tank@old" || old["dataset"] != "tank" || old["tag"] != "backup" || old["stale"] != true {
+		t.Errorf("unexpected hold: %v", old)
+	}
+	if recent["tag"] != "send" || recent["stale"] != false {
+		t.Errorf("unexpected hold: %v", recent)
+	}
+}
@@ -0,0 +1,219 @@
+package beater
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/common"
+)
+
+var lineagePropList = []string{"name", "type", "origin", "used"}
+
+// Lineage is the clone dependency graph of a pool: which snapshot every
+// clone originates from.
+type Lineage struct {
+	// Origins holds the origin snapshot of every clone.
+	Origins map[string]string
+	// Clones holds the direct clones of every origin snapshot, sorted.
+	Clones map[string][]string
+	// Used holds the used space of every dataset, and of the snapshots which
+	// are origins.
+	Used map[string]uint64
+	// Datasets holds every listed filesystem and volume.
+	Datasets map[string]bool
+}
+
+// GetLineage lists the filesystems and volumes under filter and builds their
+// clone dependency graph. The snapshots are listed afterwards, to find the
+// used space of the origins, now or in previous, the clone origins of an
+// earlier collection, without keeping every snapshot.
+func GetLineage(ctx context.Context, e Executor, filter string, previous map[string]string) (*Lineage, error) {
+	l := &Lineage{
+		Origins:  map[string]string{},
+		Clones:   map[string][]string{},
+		Used:     map[string]uint64{},
+		Datasets: map[string]bool{},
+	}
+
+	t := strings.Join([]string{DatasetFilesystem, DatasetVolume}, ",")
+	err := streamByType(ctx, e, t, filter, lineagePropList, func(d *Dataset) error {
+		if d.Used != nil {
+			l.Used[d.Name] = *d.Used
+		}
+		l.Datasets[d.Name] = true
+		if d.Origin != "" {
+			l.Origins[d.Name] = d.Origin
+			l.Clones[d.Origin] = append(l.Clones[d.Origin], d.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := map[string]bool{}
+	for _, origin := range previous {
+		wanted[origin] = true
+	}
+	for origin := range l.Clones {
+		wanted[origin] = true
+	}
+	if len(wanted) > 0 {
+		err = streamByType(ctx, e, DatasetSnapshot, filter, []string{"name", "used"}, func(d *Dataset) error {
+			if d.Used != nil && wanted[d.Name] {
+				l.Used[d.Name] = *d.Used
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, clones := range l.Clones {
+		sort.Strings(clones)
+	}
+	return l, nil
+}
+
+// Depth returns how many clones deep a dataset or snapshot is: 0 when it
+// doesn't descend from a clone, 1 for a clone of such a dataset, and so on.
+func (l *Lineage) Depth(name string) int {
+	depth := 0
+	dataset := strings.SplitN(name, "@", 2)[0]
+	for seen := map[string]bool{}; !seen[dataset]; {
+		seen[dataset] = true
+		origin, ok := l.Origins[dataset]
+		if !ok {
+			break
+		}
+		depth++
+		dataset = strings.SplitN(origin, "@", 2)[0]
+	}
+	return depth
+}
+
+// Descendants returns the clones which depend on an origin snapshot, directly
+// or through snapshots of its clones, and the deepest clone depth among them.
+func (l *Lineage) Descendants(origin string) (int, int) {
+	count, maxDepth := 0, 0
+	for _, clone := range l.Clones[origin] {
+		count++
+		if depth := l.Depth(clone); depth > maxDepth {
+			maxDepth = depth
+		}
+		for snapshot := range l.Clones {
+			if strings.HasPrefix(snapshot, clone+"@") {
+				n, depth := l.Descendants(snapshot)
+				count += n
+				if depth > maxDepth {
+					maxDepth = depth
+				}
+			}
+		}
+	}
+	return count, maxDepth
+}
+
+// collectLineage publishes one event per origin snapshot, pool by pool, along
+// with the lineage changes since the previous collection.
+func (bt *Zfsbeat) collectLineage(ctx context.Context, h *host, publish func(beat.Event)) error {
+	return bt.collectPerPool(ctx, h, publish, func(pool string) error {
+		if h.clones == nil {
+			h.clones = map[string]map[string]string{}
+		}
+		l, err := GetLineage(ctx, h.executor, pool, h.clones[pool])
+		if err != nil {
+			return err
+		}
+
+		origins := make([]string, 0, len(l.Clones))
+		for origin := range l.Clones {
+			origins = append(origins, origin)
+		}
+		sort.Strings(origins)
+		for _, origin := range origins {
+			publish(lineageEvent(l, origin))
+		}
+
+		for _, event := range lineageChanges(h.clones[pool], l) {
+			publish(event)
+		}
+		h.clones[pool] = l.Origins
+		return nil
+	})
+}
+
+func lineageEvent(l *Lineage, origin string) beat.Event {
+	clones := l.Clones[origin]
+	var clonesUsed uint64
+	for _, clone := range clones {
+		clonesUsed += l.Used[clone]
+	}
+	descendants, maxDepth := l.Descendants(origin)
+
+	// The lineage fields have their own namespace, as clones is a string on
+	// the dataset events.
+	fields := common.MapStr{
+		"source":                    "lineage",
+		"name":                      origin,
+		"dataset":                   strings.SplitN(origin, "@", 2)[0],
+		"lineage.clones.names":      clones,
+		"lineage.clones.count":      len(clones),
+		"lineage.clones.used":       clonesUsed,
+		"lineage.descendants.count": descendants,
+		"lineage.depth":             l.Depth(origin),
+		"lineage.max_depth":         maxDepth,
+		"lineage.pinned.bytes":      l.Used[origin],
+	}
+
+	return beat.Event{
+		Timestamp: time.Now(),
+		Fields:    fields,
+	}
+}
+
+// lineageChanges compares the clone origins of the previous collection with
+// the current lineage. A clone which still exists but has no origin anymore
+// was promoted; an origin snapshot which still exists but lost all its clones
+// is orphaned and no longer pinned.
+func lineageChanges(previous map[string]string, l *Lineage) []beat.Event {
+	clones := make([]string, 0, len(previous))
+	for clone := range previous {
+		clones = append(clones, clone)
+	}
+	sort.Strings(clones)
+
+	var events []beat.Event
+	orphaned := map[string]bool{}
+	for _, clone := range clones {
+		origin := previous[clone]
+		if _, ok := l.Origins[clone]; !ok && l.Datasets[clone] {
+			events = append(events, lineageChangeEvent("promoted", clone, origin))
+		}
+		if _, ok := l.Used[origin]; ok && len(l.Clones[origin]) == 0 && !orphaned[origin] {
+			orphaned[origin] = true
+			events = append(events, lineageChangeEvent("orphaned", origin, ""))
+		}
+	}
+	return events
+}
+
+func lineageChangeEvent(change, name, origin string) beat.Event {
+	fields := common.MapStr{
+		"source": "lineage_change",
+		"change": change,
+		"name":   name,
+	}
+	if origin != "" {
+		fields["origin"] = origin
+	}
+
+	return beat.Event{
+		Timestamp: time.Now(),
+		Fields:    fields,
+	}
+}
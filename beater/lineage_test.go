@@ -0,0 +1,86 @@
+// +build !integration
+
+package beater
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/beat"
+
+	"github.com/maireanu/zfsbeat/config"
+)
+
+func TestCollectLineage(t *testing.T) {
+	e := NewFakeExecutor()
+	e.On(FakeResult{Stdout: "tank\n"}, "zpool", "list", "-H", "-o", "name")
+	e.On(FakeResult{Stdout: "tank/base\tfilesystem\t-\t1000\n" +
+		"tank/vm1\tfilesystem\ttank/base@gold\t50\n" +
+		"tank/vm2\tfilesystem\ttank/base@gold\t70\n" +
+		"tank/vm1a\tfilesystem\ttank/vm1@v1\t10\n"},
+		"zfs", "list", "-rpH", "-t", "filesystem,volume", "-o", "name,type,origin,used", "tank")
+	e.On(FakeResult{Stdout: "tank/base@gold\t300\n" +
+		"tank/base@daily\t5\n" +
+		"tank/vm1@v1\t20\n"},
+		"zfs", "list", "-rpH", "-t", "snapshot", "-o", "name,used", "tank")
+
+	bt := newLocalZfsbeat(config.DefaultConfig, e)
+
+	var events []beat.Event
+	err := bt.collectLineage(context.Background(), bt.hosts[0], func(event beat.Event) {
+		events = append(events, event)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	gold := events[0].Fields
+	if gold["name"] != "tank/base@gold" || !reflect.DeepEqual(gold["lineage.clones.names"], []string{"tank/vm1", "tank/vm2"}) {
+		t.Errorf("unexpected event: %v", gold)
+	}
+	if gold["lineage.clones.used"] != uint64(120) || gold["lineage.pinned.bytes"] != uint64(300) ||
+		gold["lineage.descendants.count"] != 3 || gold["lineage.depth"] != 0 || gold["lineage.max_depth"] != 2 {
+		t.Errorf("unexpected lineage: %v", gold)
+	}
+	if v1 := events[1].Fields; v1["name"] != "tank/vm1@v1" || v1["lineage.depth"] != 1 || v1["lineage.max_depth"] != 2 {
+		t.Errorf("unexpected event: %v", v1)
+	}
+
+	l, err := GetLineage(context.Background(), e, "tank", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := l.Used["tank/base@daily"]; ok {
+		t.Error("expected the used space of snapshots which aren't origins to be dropped")
+	}
+}
+
+func TestLineageChanges(t *testing.T) {
+	previous := map[string]string{
+		"tank/vm1": "tank/base@gold",
+		"tank/vm2": "tank/base@gold",
+	}
+	l := &Lineage{
+		Origins:  map[string]string{"tank/base": "tank/vm1@gold"},
+		Clones:   map[string][]string{"tank/vm1@gold": {"tank/base"}},
+		Used:     map[string]uint64{"tank/base": 10, "tank/vm1": 20, "tank/vm1@gold": 30, "tank/old@snap": 5},
+		Datasets: map[string]bool{"tank/base": true, "tank/vm1": true},
+	}
+
+	events := lineageChanges(previous, l)
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(events))
+	}
+	if f := events[0].Fields; f["change"] != "promoted" || f["name"] != "tank/vm1" || f["origin"] != "tank/base@gold" {
+		t.Errorf("unexpected event: %v", f)
+	}
+
+	events = lineageChanges(map[string]string{"tank/gone": "tank/old@snap"}, l)
+	if len(events) != 1 || events[0].Fields["change"] != "orphaned" || events[0].Fields["name"] != "tank/old@snap" {
+		t.Errorf("expected tank/old@snap to be orphaned, got %v", events)
+	}
+}
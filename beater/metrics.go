@@ -0,0 +1,167 @@
+package beater
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/monitoring"
+)
+
+// Self monitoring metrics, reported under the zfsbeat namespace of the stats
+// API and to Stack Monitoring.
+var (
+	metricsRegistry = monitoring.Default.NewRegistry("zfsbeat", monitoring.Report)
+	commandRegistry = metricsRegistry.NewRegistry("command")
+	sourceRegistry  = metricsRegistry.NewRegistry("source")
+	cycleRegistry   = metricsRegistry.NewRegistry("cycle")
+
+	cycleOverruns = monitoring.NewInt(cycleRegistry, "overruns")
+	cycleDuration = newHistogram(cycleRegistry, "duration")
+
+	statsMutex   sync.Mutex
+	commandStats = map[string]*commandMetrics{}
+	sourceStats  = map[string]*sourceMetrics{}
+)
+
+// durationBuckets are the upper bounds of the duration histograms.
+var durationBuckets = []struct {
+	name  string
+	bound time.Duration
+}{
+	{"le_10ms", 10 * time.Millisecond},
+	{"le_100ms", 100 * time.Millisecond},
+	{"le_1s", time.Second},
+	{"le_10s", 10 * time.Second},
+	{"le_60s", 60 * time.Second},
+}
+
+// histogram counts observed durations in cumulative buckets, the count being
+// the implicit unbounded bucket.
+type histogram struct {
+	count   *monitoring.Int
+	sumMs   *monitoring.Int
+	buckets []*monitoring.Int
+}
+
+func newHistogram(parent *monitoring.Registry, name string) *histogram {
+	reg := parent.NewRegistry(name)
+	h := &histogram{
+		count: monitoring.NewInt(reg, "count"),
+		sumMs: monitoring.NewInt(reg, "sum_ms"),
+	}
+	for _, b := range durationBuckets {
+		h.buckets = append(h.buckets, monitoring.NewInt(reg, b.name))
+	}
+	return h
+}
+
+// Observe records a single duration.
+func (h *histogram) Observe(d time.Duration) {
+	h.count.Inc()
+	h.sumMs.Add(int64(d / time.Millisecond))
+	for i, b := range durationBuckets {
+		if d <= b.bound {
+			h.buckets[i].Inc()
+		}
+	}
+}
+
+// commandMetrics are the metrics of a single zfs or zpool subcommand, such as
+// `zfs list`.
+type commandMetrics struct {
+	runs     *monitoring.Int
+	failures *monitoring.Int
+	timeouts *monitoring.Int
+	lines    *monitoring.Int
+	duration *histogram
+}
+
+func getCommandMetrics(name string, arg []string) *commandMetrics {
+	// Only zfs and zpool have subcommands, the first argument of other
+	// commands may well be a path.
+	key := name
+	if len(arg) > 0 && (name == "zfs" || name == "zpool") {
+		key += "_" + arg[0]
+	}
+
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+
+	m, ok := commandStats[key]
+	if !ok {
+		reg := commandRegistry.NewRegistry(key)
+		m = &commandMetrics{
+			runs:     monitoring.NewInt(reg, "runs"),
+			failures: monitoring.NewInt(reg, "failures"),
+			timeouts: monitoring.NewInt(reg, "timeouts"),
+			lines:    monitoring.NewInt(reg, "lines"),
+			duration: newHistogram(reg, "duration"),
+		}
+		commandStats[key] = m
+	}
+	return m
+}
+
+// done records a finished run of the command.
+func (m *commandMetrics) done(start time.Time, lines int, err error) {
+	m.runs.Inc()
+	m.lines.Add(int64(lines))
+	m.duration.Observe(time.Since(start))
+	if err != nil {
+		m.failures.Inc()
+		if errors.Is(err, ErrTimeout) {
+			m.timeouts.Inc()
+		}
+	}
+}
+
+// sourceMetrics are the metrics of a collected source, summed over all hosts.
+type sourceMetrics struct {
+	events *monitoring.Int
+	errors *monitoring.Int
+}
+
+func getSourceMetrics(name string) *sourceMetrics {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+
+	m, ok := sourceStats[name]
+	if !ok {
+		reg := sourceRegistry.NewRegistry(name)
+		m = &sourceMetrics{
+			events: monitoring.NewInt(reg, "events"),
+			errors: monitoring.NewInt(reg, "errors"),
+		}
+		sourceStats[name] = m
+	}
+	return m
+}
+
+// monitoredExecutor records the metrics of every command run by an Executor.
+type monitoredExecutor struct {
+	Executor
+}
+
+func (e monitoredExecutor) Run(ctx context.Context, name string, arg ...string) ([][]string, error) {
+	m := getCommandMetrics(name, arg)
+	start := time.Now()
+
+	out, err := e.Executor.Run(ctx, name, arg...)
+	m.done(start, len(out), err)
+	return out, err
+}
+
+func (e monitoredExecutor) Stream(ctx context.Context, fn func(fields []string) error, name string, arg ...string) error {
+	m := getCommandMetrics(name, arg)
+	start := time.Now()
+
+	lines := 0
+	err := e.Executor.Stream(ctx, func(fields []string) error {
+		lines++
+		return fn(fields)
+	}, name, arg...)
+	m.done(start, lines, err)
+	return err
+}
@@ -0,0 +1,50 @@
+// +build !integration
+
+package beater
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/monitoring"
+)
+
+func TestMonitoredExecutor(t *testing.T) {
+	fake := NewFakeExecutor()
+	fake.On(FakeResult{Stdout: "tank\nbackup\n"}, "zpool", "list", "-H", "-o", "name")
+	fake.On(FakeResult{Stderr: "cannot open 'gone': no such pool", ExitCode: 1}, "zpool", "list", "-H", "-o", "name", "gone")
+	e := monitoredExecutor{fake}
+
+	m := getCommandMetrics("zpool", []string{"list"})
+	runs, failures, lines := m.runs.Get(), m.failures.Get(), m.lines.Get()
+
+	e.Run(context.Background(), "zpool", "list", "-H", "-o", "name")
+	e.Run(context.Background(), "zpool", "list", "-H", "-o", "name", "gone")
+
+	if d := m.runs.Get() - runs; d != 2 {
+		t.Errorf("expected 2 runs, got %d", d)
+	}
+	if d := m.failures.Get() - failures; d != 1 {
+		t.Errorf("expected 1 failure, got %d", d)
+	}
+	if d := m.lines.Get() - lines; d != 2 {
+		t.Errorf("expected 2 lines, got %d", d)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram(monitoring.NewRegistry(), "duration")
+	h.Observe(50 * time.Millisecond)
+	h.Observe(2 * time.Minute)
+
+	if h.count.Get() != 2 || h.sumMs.Get() != 120050 {
+		t.Errorf("unexpected count %d and sum %d", h.count.Get(), h.sumMs.Get())
+	}
+	expected := []int64{0, 1, 1, 1, 1}
+	for i, b := range h.buckets {
+		if b.Get() != expected[i] {
+			t.Errorf("bucket %s: expected %d, got %d", durationBuckets[i].name, expected[i], b.Get())
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package beater
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// DiscoverProperties returns the native dataset properties supported by the
+// installed ZFS release, with "name" first. They are read from the property
+// table which `zfs get` prints with its usage when called without arguments.
+func DiscoverProperties(ctx context.Context, e Executor) ([]string, error) {
+	_, err := zfs(ctx, e, "get")
+
+	var zerr *Error
+	if !errors.As(err, &zerr) || zerr.Type != "" {
+		if err == nil {
+			err = errors.New("zfs get printed no usage")
+		}
+		return nil, err
+	}
+
+	props := parsePropertyTable(zerr.Stderr)
+	if len(props) == 0 {
+		return nil, zerr
+	}
+	return props, nil
+}
+
+// parsePropertyTable parses the PROPERTY EDIT INHERIT VALUES table of the zfs
+// usage. Per-user, per-group and per-snapshot properties such as
+// `userused@...` and `written@<snap>` are skipped.
+func parsePropertyTable(usage string) []string {
+	props := []string{"name"}
+
+	inTable := false
+	for _, line := range strings.Split(usage, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "PROPERTY" {
+			inTable = true
+			continue
+		}
+		if !inTable || len(fields) < 3 || (fields[1] != "YES" && fields[1] != "NO") {
+			continue
+		}
+
+		prop := fields[0]
+		if prop == "name" || strings.ContainsAny(prop, "@<") {
+			continue
+		}
+		props = append(props, prop)
+	}
+
+	if len(props) == 1 {
+		return nil
+	}
+	return props
+}
+
+// discoverProperties sets the dataset properties listed on a host, falling
+// back to dsPropList when they can't be discovered.
+func (bt *Zfsbeat) discoverProperties(h *host) {
+	props, err := DiscoverProperties(bt.ctx, h.executor)
+	if err != nil {
+		logp.Warn("Cannot discover the supported dataset properties%s, using the built-in list: %v", h, err)
+		h.properties = dsPropList
+		return
+	}
+
+	logp.Debug("zfsbeat", "Discovered %d dataset properties%s", len(props), h)
+	h.properties = props
+}
@@ -0,0 +1,74 @@
+// +build !integration
+
+package beater
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+const zfsGetUsage = `missing property argument
+usage:
+	get [-rHp] [-d max] [-o "all" | field[,...]]
+	    [-t type[,...]] [-s source[,...]]
+	    <"all" | property[,...]> [filesystem|volume|snapshot|bookmark] ...
+
+The following properties are supported:
+
+	PROPERTY       EDIT  INHERIT   VALUES
+
+	available        NO       NO   <size>
+	name             NO       NO   <string>
+	used             NO       NO   <size>
+	compression     YES      YES   on | off | lzjb | gzip | gzip-[1-9] | zle | lz4
+	special_small_blocks YES  YES  zero or 512 to 1M, power of 2
+	userused@...     NO       NO   <size>
+	written@<snap>   NO       NO   <size>
+
+Sizes are specified in bytes with standard units such as K, M, G, etc.
+
+User-defined properties can be specified by using a name containing a colon (:).
+`
+
+func TestDiscoverProperties(t *testing.T) {
+	e := NewFakeExecutor()
+	e.On(FakeResult{Stderr: zfsGetUsage, ExitCode: 2}, "zfs", "get")
+
+	props, err := DiscoverProperties(context.Background(), e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"name", "available", "used", "compression", "special_small_blocks"}
+	if !reflect.DeepEqual(props, expected) {
+		t.Errorf("expected %v, got %v", expected, props)
+	}
+}
+
+func TestStreamDiscoveredProperties(t *testing.T) {
+	props := []string{"name", "used", "special_small_blocks", "mountpoint"}
+
+	e := NewFakeExecutor()
+	e.On(FakeResult{Stdout: "tank/my data\t1024\t0\t/tank/my data\n"},
+		"zfs", "list", "-rpH", "-t", "filesystem", "-o", "name,used,special_small_blocks,mountpoint", "tank")
+
+	var datasets []*Dataset
+	err := streamByType(context.Background(), e, DatasetFilesystem, "tank", props, func(d *Dataset) error {
+		datasets = append(datasets, d)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(datasets) != 1 {
+		t.Fatalf("expected 1 dataset, got %d", len(datasets))
+	}
+	d := datasets[0]
+	if d.Name != "tank/my data" || *d.Used != 1024 || d.Mountpoint != "/tank/my data" {
+		t.Errorf("unexpected dataset: %+v", d)
+	}
+	if d.Properties["special_small_blocks"] != "0" {
+		t.Errorf("expected the unknown property to be kept, got %v", d.Properties)
+	}
+}
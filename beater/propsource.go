@@ -0,0 +1,54 @@
+package beater
+
+import (
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// Property sources, as printed in the SOURCE column of `zfs get`.
+const (
+	SourceLocal     = "local"
+	SourceDefault   = "default"
+	SourceInherited = "inherited"
+	SourceReceived  = "received"
+	SourceTemporary = "temporary"
+)
+
+// PropertySource tells where the value of a dataset property comes from.
+// InheritedFrom is the dataset the value is inherited from, if any.
+type PropertySource struct {
+	Type          string
+	InheritedFrom string
+}
+
+// parsePropertySource parses a SOURCE column, such as `inherited from tank`.
+func parsePropertySource(s string) PropertySource {
+	if strings.HasPrefix(s, "inherited from ") {
+		return PropertySource{
+			Type:          SourceInherited,
+			InheritedFrom: strings.TrimPrefix(s, "inherited from "),
+		}
+	}
+	return PropertySource{Type: s}
+}
+
+// propertySourceFields returns the event fields for the property sources of
+// a dataset: the source of every property under property_source and, for
+// inherited values, the dataset they come from under property_inherited_from.
+func propertySourceFields(sources map[string]PropertySource) common.MapStr {
+	types := common.MapStr{}
+	inherited := common.MapStr{}
+	for prop, s := range sources {
+		types[prop] = s.Type
+		if s.InheritedFrom != "" {
+			inherited[prop] = s.InheritedFrom
+		}
+	}
+
+	fields := common.MapStr{"property_source": types}
+	if len(inherited) > 0 {
+		fields["property_inherited_from"] = inherited
+	}
+	return fields
+}
@@ -0,0 +1,66 @@
+// +build !integration
+
+package beater
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestStreamPropertySources(t *testing.T) {
+	e := NewFakeExecutor()
+	e.On(FakeResult{Stdout: "tank/home\tused\t1024\t-\n" +
+		"tank/home\tcompression\tlz4\tinherited from tank\n" +
+		"tank/home\tquota\t0\tdefault\n" +
+		"tank/home\trecordsize\t1048576\tlocal\n" +
+		"tank/home\treadonly\ton\treceived\n" +
+		"tank/home\tatime\ton\tdefault\n" +
+		"tank/src\tused\t2048\t-\n" +
+		"tank/src\tcompression\toff\tlocal\n"},
+		"zfs", "get", "-rHp", "-t", "filesystem", "-o", "name,property,value,source", "all", "tank")
+
+	var datasets []*Dataset
+	props := []string{"name", "used", "compression", "quota", "recordsize", "readonly"}
+	err := streamByGet(context.Background(), e, DatasetFilesystem, "tank", props, true, nil, func(d *Dataset) error {
+		datasets = append(datasets, d)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(datasets) != 2 {
+		t.Fatalf("expected 2 datasets, got %d", len(datasets))
+	}
+
+	d := datasets[0]
+	if d.Name != "tank/home" || d.Used == nil || *d.Used != 1024 || d.Compression != "lz4" || d.Readonly == nil || !*d.Readonly {
+		t.Errorf("unexpected dataset: %+v", d)
+	}
+	if d.Atime != nil {
+		t.Errorf("expected atime not to be listed, got %v", *d.Atime)
+	}
+	expected := map[string]PropertySource{
+		"compression": {Type: SourceInherited, InheritedFrom: "tank"},
+		"quota":       {Type: SourceDefault},
+		"recordsize":  {Type: SourceLocal},
+		"readonly":    {Type: SourceReceived},
+	}
+	if !reflect.DeepEqual(d.Sources, expected) {
+		t.Errorf("expected %v, got %v", expected, d.Sources)
+	}
+	if s := datasets[1].Sources["compression"]; datasets[1].Name != "tank/src" || s.Type != SourceLocal {
+		t.Errorf("unexpected dataset: %+v", datasets[1])
+	}
+
+	fields := propertySourceFields(d.Sources)
+	if v, _ := fields.GetValue("property_source.compression"); v != "inherited" {
+		t.Errorf("unexpected compression source: %v", v)
+	}
+	expectedInherited := common.MapStr{"compression": "tank"}
+	if !reflect.DeepEqual(fields["property_inherited_from"], expectedInherited) {
+		t.Errorf("expected %v, got %v", expectedInherited, fields["property_inherited_from"])
+	}
+}
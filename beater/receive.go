@@ -0,0 +1,157 @@
+package beater
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// ResumeToken is the decoded receive_resume_token of a dataset whose
+// resumable receive (zfs recv -s) was interrupted. FromGUID is only set for
+// incremental streams.
+type ResumeToken struct {
+	ToName   string
+	ToGUID   string
+	FromGUID string
+	Bytes    *uint64
+}
+
+// Incremental reports whether the interrupted stream is incremental.
+func (t *ResumeToken) Incremental() bool {
+	return t.FromGUID != ""
+}
+
+// resumeToken is a receive resume token and the time it was first seen.
+type resumeToken struct {
+	token     string
+	firstSeen time.Time
+}
+
+// ResumeTokens returns the receive resume tokens of the filesystems and
+// volumes under filter, keyed by dataset. The token of a receive into an
+// existing dataset, kept in its hidden %recv child, is reported on the
+// dataset itself.
+func ResumeTokens(ctx context.Context, e Executor, filter string) (map[string]string, error) {
+	tokens := map[string]string{}
+	t := strings.Join([]string{DatasetFilesystem, DatasetVolume}, ",")
+	err := streamByType(ctx, e, t, filter, []string{"name", "receive_resume_token"}, func(d *Dataset) error {
+		if d.ReceiveResumeToken != "" {
+			tokens[d.Name] = d.ReceiveResumeToken
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// DecodeResumeToken decodes a receive resume token with `zfs send -nvt`,
+// which prints its contents as `name = value` lines.
+func DecodeResumeToken(ctx context.Context, e Executor, token string) (*ResumeToken, error) {
+	out, err := zfs(ctx, e, "send", "-nvt", token)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &ResumeToken{}
+	for _, line := range out {
+		kv := strings.SplitN(strings.TrimSpace(strings.Join(line, " ")), " = ", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "toname":
+			t.ToName = kv[1]
+		case "toguid":
+			t.ToGUID = decimalGUID(kv[1])
+		case "fromguid":
+			t.FromGUID = decimalGUID(kv[1])
+		case "bytes":
+			// Numbers are printed in hex, such as 0x5f2bc8.
+			if v, err := strconv.ParseUint(kv[1], 0, 64); err == nil {
+				t.Bytes = &v
+			}
+		}
+	}
+	return t, nil
+}
+
+// decimalGUID converts a GUID printed in hex by `zfs send -nvt` to the
+// decimal form of `zfs list -p`, so it can be compared with snapshot GUIDs.
+func decimalGUID(s string) string {
+	v, err := strconv.ParseUint(s, 0, 64)
+	if err != nil {
+		return s
+	}
+	return strconv.FormatUint(v, 10)
+}
+
+// collectReceives publishes one event per interrupted resumable receive, pool
+// by pool. The token age is the time since the token was first seen, as the
+// token itself doesn't record when the receive was interrupted.
+func (bt *Zfsbeat) collectReceives(ctx context.Context, h *host, publish func(beat.Event)) error {
+	return bt.collectPerPool(ctx, h, publish, func(pool string) error {
+		tokens, err := ResumeTokens(ctx, h.executor, pool)
+		if err != nil {
+			return err
+		}
+
+		if h.resumeTokens == nil {
+			h.resumeTokens = map[string]map[string]resumeToken{}
+		}
+		previous := h.resumeTokens[pool]
+		seen := map[string]resumeToken{}
+		now := time.Now()
+
+		datasets := make([]string, 0, len(tokens))
+		for dataset := range tokens {
+			datasets = append(datasets, dataset)
+		}
+		sort.Strings(datasets)
+		for _, dataset := range datasets {
+			token := tokens[dataset]
+			s, ok := previous[dataset]
+			if !ok || s.token != token {
+				s = resumeToken{token: token, firstSeen: now}
+			}
+			seen[dataset] = s
+
+			decoded, err := DecodeResumeToken(ctx, h.executor, token)
+			if err != nil {
+				return err
+			}
+			publish(receiveEvent(dataset, s, decoded, now))
+		}
+		h.resumeTokens[pool] = seen
+		return nil
+	})
+}
+
+func receiveEvent(dataset string, s resumeToken, t *ResumeToken, now time.Time) beat.Event {
+	fields := common.MapStr{
+		"source":             "receive",
+		"name":               dataset,
+		"token.value":        s.token,
+		"token.first_seen":   s.firstSeen,
+		"token.age_sec":      int64(now.Sub(s.firstSeen) / time.Second),
+		"resume.toname":      t.ToName,
+		"resume.toguid":      t.ToGUID,
+		"resume.bytes":       uintValue(t.Bytes),
+		"resume.incremental": t.Incremental(),
+	}
+	if t.Incremental() {
+		fields["resume.fromguid"] = t.FromGUID
+	}
+	omitNil(fields)
+
+	return beat.Event{
+		Timestamp: now,
+		Fields:    fields,
+	}
+}
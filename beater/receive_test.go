@@ -0,0 +1,56 @@
+// +build !integration
+
+package beater
+
+import (
+	"context"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/beat"
+
+	"github.com/maireanu/zfsbeat/config"
+)
+
+func TestCollectReceives(t *testing.T) {
+	e := NewFakeExecutor()
+	e.On(FakeResult{Stdout: "tank\n"}, "zpool", "list", "-H", "-o", "name")
+	e.On(FakeResult{Stdout: "backup\t-\n" +
+		"backup/home\t1-e604ea4bf-e0-789c63a2\n"},
+		"zfs", "list", "-rpH", "-t", "filesystem,volume", "-o", "name,receive_resume_token", "tank")
+	e.On(FakeResult{Stdout: "resume token contents:\n" +
+		"nvlist version: 0\n" +
+		"\tfromguid = 0x2b3a4f5e6d7c8b9a\n" +
+		"\tobject = 0x6\n" +
+		"\toffset = 0x0\n" +
+		"\tbytes = 0x5f2bc8\n" +
+		"\ttoguid = 0x1a2b3c4d5e6f7a8b\n" +
+		"\ttoname = tank/home@daily-2\n" +
+		"send from tank/home@daily-1 to tank/home@daily-2 estimated size is 2.05M\n"},
+		"zfs", "send", "-nvt", "1-e604ea4bf-e0-789c63a2")
+
+	bt := newLocalZfsbeat(config.DefaultConfig, e)
+
+	var events []beat.Event
+	for i := 0; i < 2; i++ {
+		err := bt.collectReceives(context.Background(), bt.hosts[0], func(event beat.Event) {
+			events = append(events, event)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	f := events[0].Fields
+	if f["name"] != "backup/home" || f["resume.toname"] != "tank/home@daily-2" || f["resume.bytes"] != uint64(0x5f2bc8) {
+		t.Errorf("unexpected event: %v", f)
+	}
+	if f["resume.incremental"] != true || f["resume.fromguid"] != "3114889359262518170" {
+		t.Errorf("expected an incremental stream: %v", f)
+	}
+	if events[1].Fields["token.first_seen"] != f["token.first_seen"] {
+		t.Errorf("expected the token to keep its first seen time, got %v and %v", f["token.first_seen"], events[1].Fields["token.first_seen"])
+	}
+}
@@ -0,0 +1,208 @@
+package beater
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/common"
+
+	"github.com/maireanu/zfsbeat/config"
+)
+
+// replicationPropList are the snapshot properties listed to compare the
+// sides of a replication.
+var replicationPropList = []string{"name", "guid", "creation"}
+
+// ReplicationStatus is how far the target of a replication is behind its
+// source. Common is nil when the sides have no snapshot in common, in which
+// case every source snapshot is pending.
+type ReplicationStatus struct {
+	Common       *Dataset
+	SourceNewest *Dataset
+	TargetNewest *Dataset
+	Pending      int
+	PendingBytes *uint64
+}
+
+// datasetSnapshots returns the snapshots of a dataset, not those of its
+// children, oldest first. They are listed in creation txg order, which also
+// orders the snapshots taken within the same second.
+func datasetSnapshots(ctx context.Context, e Executor, name string) ([]*Dataset, error) {
+	var snapshots []*Dataset
+	err := e.Stream(ctx, func(line []string) error {
+		d := &Dataset{}
+		if err := d.parseLine(replicationPropList, line); err != nil {
+			return err
+		}
+		snapshots = append(snapshots, d)
+		return nil
+	}, "zfs", "list", "-Hp", "-d", "1", "-t", "snapshot", "-o", strings.Join(replicationPropList, ","), "-s", "createtxg", name)
+	if err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+// CompareReplication finds the newest source snapshot which is on the target
+// as well, by GUID, and counts the source snapshots which are newer.
+func CompareReplication(source, target []*Dataset) *ReplicationStatus {
+	s := &ReplicationStatus{}
+	onTarget := map[string]bool{}
+	for _, d := range target {
+		onTarget[d.GUID] = true
+	}
+	if len(source) > 0 {
+		s.SourceNewest = source[len(source)-1]
+	}
+	if len(target) > 0 {
+		s.TargetNewest = target[len(target)-1]
+	}
+
+	for i := len(source) - 1; i >= 0; i-- {
+		if onTarget[source[i].GUID] {
+			s.Common = source[i]
+			break
+		}
+		s.Pending++
+	}
+	return s
+}
+
+// writtenSince returns the space written to a snapshot or dataset since an
+// older snapshot of the same dataset, from its written@snapshot property.
+func writtenSince(ctx context.Context, e Executor, name, since string) (uint64, error) {
+	prop := "written@" + strings.SplitN(since, "@", 2)[1]
+	out, err := zfs(ctx, e, "get", "-Hp", "-o", "value", prop, name)
+	if err != nil {
+		return 0, err
+	}
+	if len(out) != 1 || len(out[0]) != 1 {
+		return 0, fmt.Errorf("unexpected output of zfs get %s %s: %v", prop, name, out)
+	}
+
+	var written uint64
+	err = setUint(&written, out[0][0])
+	return written, err
+}
+
+// replications returns the replications whose source is on a host.
+func (bt *Zfsbeat) replications(h *host) []config.Replication {
+	var replications []config.Replication
+	for _, r := range bt.config.Replication {
+		if r.Source.Host == h.name {
+			replications = append(replications, r)
+		}
+	}
+	return replications
+}
+
+// hostByName returns the host with the given name, or nil.
+func (bt *Zfsbeat) hostByName(name string) *host {
+	for _, h := range bt.hosts {
+		if h.name == name {
+			return h
+		}
+	}
+	return nil
+}
+
+// replicatesLocal reports whether a side of any replication is on the local
+// host.
+func (bt *Zfsbeat) replicatesLocal() bool {
+	for _, r := range bt.config.Replication {
+		if r.Source.Host == "" || r.Target.Host == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// checkReplication checks that both sides of every replication are on a
+// collected host.
+func (bt *Zfsbeat) checkReplication() error {
+	for _, r := range bt.config.Replication {
+		for _, name := range []string{r.Source.Host, r.Target.Host} {
+			if bt.hostByName(name) == nil {
+				return fmt.Errorf("Error in replication %s: unknown host %q", r.Name, name)
+			}
+		}
+	}
+	return nil
+}
+
+// collectReplication publishes the lag of every replication whose source is
+// on the host. The target is listed on its own host.
+func (bt *Zfsbeat) collectReplication(ctx context.Context, h *host, publish func(beat.Event)) error {
+	for _, r := range bt.replications(h) {
+		target := bt.hostByName(r.Target.Host)
+		if !bt.allowDataset(h, r.Source.Dataset) || !bt.allowDataset(target, r.Target.Dataset) {
+			continue
+		}
+
+		sourceSnapshots, err := datasetSnapshots(ctx, h.executor, r.Source.Dataset)
+		if err != nil {
+			return err
+		}
+		targetSnapshots, err := datasetSnapshots(ctx, target.executor, r.Target.Dataset)
+		if err != nil {
+			return err
+		}
+
+		s := CompareReplication(sourceSnapshots, targetSnapshots)
+		if s.Common != nil && s.Pending > 0 {
+			written, err := writtenSince(ctx, h.executor, s.SourceNewest.Name, s.Common.Name)
+			if err != nil {
+				return err
+			}
+			s.PendingBytes = &written
+		}
+		publish(replicationEvent(r, s))
+	}
+	return nil
+}
+
+func replicationEvent(r config.Replication, s *ReplicationStatus) beat.Event {
+	name := r.Name
+	if name == "" {
+		name = r.Source.Dataset
+	}
+
+	fields := common.MapStr{
+		"source":            "replication",
+		"name":              name,
+		"send.dataset":      r.Source.Dataset,
+		"recv.dataset":      r.Target.Dataset,
+		"lag.snapshots":     s.Pending,
+		"in_sync":           s.Common != nil && s.Pending == 0,
+		"lag.pending_bytes": uintValue(s.PendingBytes),
+	}
+	if r.Source.Host != "" {
+		fields["send.host"] = r.Source.Host
+	}
+	if r.Target.Host != "" {
+		fields["recv.host"] = r.Target.Host
+	}
+	if s.SourceNewest != nil {
+		fields["send.newest"] = s.SourceNewest.Name
+	}
+	if s.TargetNewest != nil {
+		fields["recv.newest"] = s.TargetNewest.Name
+	}
+	if s.Common != nil {
+		fields["common.snapshot"] = s.Common.Name
+		fields["common.guid"] = s.Common.GUID
+		fields["common.creation"] = timeValue(s.Common.Creation)
+		if s.Common.Creation != nil && s.SourceNewest.Creation != nil {
+			fields["lag.sec"] = int64(s.SourceNewest.Creation.Sub(*s.Common.Creation) / time.Second)
+		}
+	}
+	omitNil(fields)
+
+	return beat.Event{
+		Timestamp: time.Now(),
+		Fields:    fields,
+	}
+}
@@ -0,0 +1,101 @@
+// +build !integration
+
+package beater
+
+import (
+	"context"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/common"
+
+	"github.com/maireanu/zfsbeat/config"
+)
+
+func TestCollectReplication(t *testing.T) {
+	src := NewFakeExecutor()
+	src.On(FakeResult{Stdout: "tank/home@a\t111\t1546300800\n" +
+		"tank/home@b\t222\t1546304400\n" +
+		"tank/home@c\t333\t1546308000\n" +
+		"tank/home@d\t444\t1546311600\n"},
+		"zfs", "list", "-Hp", "-d", "1", "-t", "snapshot", "-o", "name,guid,creation", "-s", "createtxg", "tank/home")
+	src.On(FakeResult{Stdout: "8192\n"}, "zfs", "get", "-Hp", "-o", "value", "written@b", "tank/home@d")
+
+	dst := NewFakeExecutor()
+	dst.On(FakeResult{Stdout: "backup/home@a\t111\t1546300800\n" +
+		"backup/home@b\t222\t1546304400\n"},
+		"zfs", "list", "-Hp", "-d", "1", "-t", "snapshot", "-o", "name,guid,creation", "-s", "createtxg", "backup/home")
+
+	c := config.DefaultConfig
+	c.Replication = []config.Replication{{
+		Name:   "home",
+		Source: config.ReplicationSide{Host: "nas1", Dataset: "tank/home"},
+		Target: config.ReplicationSide{Host: "nas2", Dataset: "backup/home"},
+	}}
+	bt := newZfsbeat(c)
+	bt.addHost("nas1", "nas1.example.com", src)
+	bt.addHost("nas2", "nas2.example.com", dst)
+	if err := bt.checkReplication(); err != nil {
+		t.Fatal(err)
+	}
+	if len(bt.hosts[1].sources) != len(bt.hosts[0].sources)-1 {
+		t.Errorf("expected the replication to be collected on the source host only")
+	}
+
+	var events []beat.Event
+	err := bt.collectReplication(context.Background(), bt.hosts[0], func(event beat.Event) {
+		events = append(events, event)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(events))
+	}
+	f := events[0].Fields
+	if f["common.snapshot"] != "tank/home@b" || f["send.newest"] != "tank/home@d" || f["recv.newest"] != "backup/home@b" {
+		t.Errorf("unexpected snapshots: %v", f)
+	}
+	if f["lag.snapshots"] != 2 || f["lag.sec"] != int64(7200) || f["lag.pending_bytes"] != uint64(8192) || f["in_sync"] != false {
+		t.Errorf("unexpected lag: %v", f)
+	}
+}
+
+func TestCheckReplicationUnknownHost(t *testing.T) {
+	c := config.DefaultConfig
+	c.Replication = []config.Replication{{
+		Source: config.ReplicationSide{Dataset: "tank/home"},
+		Target: config.ReplicationSide{Host: "nas2", Dataset: "backup/home"},
+	}}
+	bt := newLocalZfsbeat(c, NewFakeExecutor())
+
+	if err := bt.checkReplication(); err == nil {
+		t.Error("expected an error for the unknown target host")
+	}
+}
+
+func TestReplicationToLocalHost(t *testing.T) {
+	cfg, err := common.NewConfigFrom(map[string]interface{}{
+		"hosts": []map[string]interface{}{{"name": "nas1", "address": "nas1.example.com"}},
+		"replication": []map[string]interface{}{{
+			"source": map[string]interface{}{"host": "nas1", "dataset": "tank/home"},
+			"target": map[string]interface{}{"dataset": "backup/home"},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := New(nil, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bt := b.(*Zfsbeat)
+	if len(bt.hosts) != 2 {
+		t.Fatalf("expected the local host to be added, got %d hosts", len(bt.hosts))
+	}
+	if local := bt.hostByName(""); local == nil || len(local.sources) != 0 {
+		t.Errorf("expected the local host to collect nothing as a replication target")
+	}
+}
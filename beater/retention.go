@@ -0,0 +1,149 @@
+package beater
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/common"
+
+	"github.com/maireanu/zfsbeat/config"
+)
+
+// Retention policy violations.
+const (
+	ViolationMissing = "missing"
+	ViolationTooMany = "too_many"
+	ViolationGaps    = "gaps"
+)
+
+// RetentionReport is the compliance of a dataset with a retention policy.
+type RetentionReport struct {
+	Dataset    string
+	Policy     config.Retention
+	Count      int
+	Newest     *time.Time
+	Gaps       int
+	MaxGap     time.Duration
+	Violations []string
+}
+
+// CheckRetention checks the snapshots of a dataset against a retention policy
+// at time now, given the creation times of the snapshots with the naming
+// prefix of the policy.
+func CheckRetention(dataset string, times []time.Time, p config.Retention, now time.Time) *RetentionReport {
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+	grace := p.Grace
+	if grace == 0 {
+		grace = p.Interval / 4
+	}
+
+	r := &RetentionReport{Dataset: dataset, Policy: p, Count: len(times)}
+	for i := 1; i < len(times); i++ {
+		if gap := times[i].Sub(times[i-1]); gap > p.Interval+grace {
+			r.Gaps++
+			if gap > r.MaxGap {
+				r.MaxGap = gap
+			}
+		}
+	}
+	if len(times) > 0 {
+		r.Newest = &times[len(times)-1]
+	}
+
+	if r.Newest == nil || now.Sub(*r.Newest) > p.Interval+grace {
+		r.Violations = append(r.Violations, ViolationMissing)
+	}
+	if p.Keep > 0 && r.Count > p.Keep {
+		r.Violations = append(r.Violations, ViolationTooMany)
+	}
+	if r.Gaps > 0 {
+		r.Violations = append(r.Violations, ViolationGaps)
+	}
+	return r
+}
+
+// collectRetention publishes, pool by pool, one compliance event for every
+// dataset and retention policy which applies to it.
+func (bt *Zfsbeat) collectRetention(ctx context.Context, h *host, publish func(beat.Event)) error {
+	return bt.collectPerPool(ctx, h, publish, func(pool string) error {
+		var datasets []string
+		t := strings.Join([]string{DatasetFilesystem, DatasetVolume}, ",")
+		err := streamByType(ctx, h.executor, t, pool, []string{"name"}, func(d *Dataset) error {
+			datasets = append(datasets, d.Name)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// Only the creation times of the snapshots some policy applies to are
+		// kept, by policy and dataset.
+		policies := bt.config.Retention
+		times := make([]map[string][]time.Time, len(policies))
+		for i := range times {
+			times[i] = map[string][]time.Time{}
+		}
+		err = streamByType(ctx, h.executor, DatasetSnapshot, pool, []string{"name", "creation"}, func(d *Dataset) error {
+			parts := strings.SplitN(d.Name, "@", 2)
+			if d.Creation == nil || len(parts) != 2 {
+				return nil
+			}
+			for i, p := range policies {
+				if strings.HasPrefix(parts[1], p.Prefix) && matchAny(p.Datasets, parts[0]) {
+					times[i][parts[0]] = append(times[i][parts[0]], *d.Creation)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for _, dataset := range datasets {
+			for i, p := range policies {
+				if matchAny(p.Datasets, dataset) {
+					publish(retentionEvent(CheckRetention(dataset, times[i][dataset], p, now), now))
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func retentionEvent(r *RetentionReport, now time.Time) beat.Event {
+	name := r.Policy.Name
+	if name == "" {
+		name = r.Policy.Prefix
+	}
+
+	fields := common.MapStr{
+		"source":           "retention",
+		"dataset":          r.Dataset,
+		"policy.name":      name,
+		"policy.prefix":    r.Policy.Prefix,
+		"policy.interval":  r.Policy.Interval.String(),
+		"policy.keep":      r.Policy.Keep,
+		"snapshots.count":  r.Count,
+		"snapshots.newest": timeValue(r.Newest),
+		"gaps.count":       r.Gaps,
+		"gaps.max_sec":     int64(r.MaxGap / time.Second),
+		"compliant":        len(r.Violations) == 0,
+	}
+	if r.Newest != nil {
+		fields["snapshots.age_sec"] = int64(now.Sub(*r.Newest) / time.Second)
+	}
+	if len(r.Violations) > 0 {
+		fields["violations"] = r.Violations
+	}
+	omitNil(fields)
+
+	return beat.Event{
+		Timestamp: now,
+		Fields:    fields,
+	}
+}
@@ -0,0 +1,74 @@
+// +build !integration
+
+package beater
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/beat"
+
+	"github.com/maireanu/zfsbeat/config"
+)
+
+func TestCheckRetention(t *testing.T) {
+	now := time.Date(2019, 1, 2, 0, 10, 0, 0, time.UTC)
+	hourly := config.Retention{Prefix: "hourly-", Interval: time.Hour, Keep: 3}
+
+	tests := []struct {
+		name       string
+		times      []time.Time
+		violations []string
+	}{
+		{"compliant", []time.Time{now.Add(-130 * time.Minute), now.Add(-70 * time.Minute), now.Add(-10 * time.Minute)}, nil},
+		{"missing", []time.Time{now.Add(-200 * time.Minute), now.Add(-140 * time.Minute), now.Add(-80 * time.Minute)}, []string{ViolationMissing}},
+		{"none", nil, []string{ViolationMissing}},
+		{"too many", []time.Time{now.Add(-190 * time.Minute), now.Add(-130 * time.Minute), now.Add(-70 * time.Minute), now.Add(-10 * time.Minute)}, []string{ViolationTooMany}},
+		{"gap", []time.Time{now.Add(-190 * time.Minute), now.Add(-10 * time.Minute)}, []string{ViolationGaps}},
+	}
+	for _, test := range tests {
+		r := CheckRetention("tank/home", test.times, hourly, now)
+		if !reflect.DeepEqual(r.Violations, test.violations) {
+			t.Errorf("%s: expected violations %v, got %v", test.name, test.violations, r.Violations)
+		}
+	}
+}
+
+func TestCollectRetention(t *testing.T) {
+	creation := time.Now().Add(-2 * time.Hour).Unix()
+
+	e := NewFakeExecutor()
+	e.On(FakeResult{Stdout: "tank\n"}, "zpool", "list", "-H", "-o", "name")
+	e.On(FakeResult{Stdout: "tank\ntank/home\ntank/vm\n"},
+		"zfs", "list", "-rpH", "-t", "filesystem,volume", "-o", "name", "tank")
+	e.On(FakeResult{Stdout: fmt.Sprintf("tank/home@daily-1\t%d\ntank/home@hourly-1\t%d\ntank@daily-1\t%d\n", creation, creation, creation)},
+		"zfs", "list", "-rpH", "-t", "snapshot", "-o", "name,creation", "tank")
+
+	c := config.DefaultConfig
+	c.Retention = []config.Retention{
+		{Name: "daily", Datasets: []string{"tank/*"}, Prefix: "daily-", Interval: 24 * time.Hour, Keep: 30},
+	}
+	bt := newLocalZfsbeat(c, e)
+
+	var events []beat.Event
+	err := bt.collectRetention(context.Background(), bt.hosts[0], func(event beat.Event) {
+		events = append(events, event)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	home, vm := events[0].Fields, events[1].Fields
+	if home["dataset"] != "tank/home" || home["policy.name"] != "daily" || home["compliant"] != true || home["snapshots.count"] != 1 {
+		t.Errorf("unexpected event: %v", home)
+	}
+	if vm["dataset"] != "tank/vm" || vm["compliant"] != false || !reflect.DeepEqual(vm["violations"], []string{ViolationMissing}) {
+		t.Errorf("unexpected event: %v", vm)
+	}
+}
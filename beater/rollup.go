@@ -0,0 +1,128 @@
+package beater
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// rollupPropList are the snapshot properties listed in rollup mode.
+var rollupPropList = []string{"name", "creation", "used", "written", "userrefs"}
+
+// SnapshotRollup summarises the snapshots of a dataset.
+type SnapshotRollup struct {
+	Dataset  string
+	Count    int
+	Oldest   *time.Time
+	Newest   *time.Time
+	Used     uint64
+	Written  uint64
+	Prefixes map[string]int
+}
+
+// Add counts a snapshot of the dataset.
+func (r *SnapshotRollup) Add(d *Dataset) {
+	r.Count++
+	if c := d.Creation; c != nil {
+		if r.Oldest == nil || c.Before(*r.Oldest) {
+			r.Oldest = c
+		}
+		if r.Newest == nil || c.After(*r.Newest) {
+			r.Newest = c
+		}
+	}
+	if d.Used != nil {
+		r.Used += *d.Used
+	}
+	if d.Written != nil {
+		r.Written += *d.Written
+	}
+
+	if r.Prefixes == nil {
+		r.Prefixes = map[string]int{}
+	}
+	r.Prefixes[snapshotPrefix(d.Name)]++
+}
+
+// noPrefix is the prefix of the snapshots whose name starts with a digit.
+const noPrefix = "_none"
+
+// snapshotPrefix returns the naming prefix of a snapshot: its short name up
+// to the first digit, without trailing separators. For example the prefix of
+// tank@zfs-auto-snap_hourly-2019-01-01-1200 is zfs-auto-snap_hourly. As the
+// prefix is an event key, dots are replaced with underscores so it isn't
+// split into objects, and an empty prefix is noPrefix.
+func snapshotPrefix(name string) string {
+	if i := strings.Index(name, "@"); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.IndexFunc(name, unicode.IsDigit); i >= 0 {
+		name = name[:i]
+	}
+	name = strings.TrimRight(name, "-_.:")
+	if name == "" {
+		return noPrefix
+	}
+	return strings.Replace(name, ".", "_", -1)
+}
+
+// rollupSnapshots publishes one rollup event per dataset with snapshots, pool
+// by pool. Every snapshot is handed to fn as well.
+func (bt *Zfsbeat) rollupSnapshots(ctx context.Context, h *host, publish func(beat.Event), fn func(*Dataset)) error {
+	return bt.collectPerPool(ctx, h, publish, func(pool string) error {
+		rollups := map[string]*SnapshotRollup{}
+		err := streamByType(ctx, h.executor, DatasetSnapshot, pool, rollupPropList, func(d *Dataset) error {
+			dataset := strings.SplitN(d.Name, "@", 2)[0]
+			r, ok := rollups[dataset]
+			if !ok {
+				r = &SnapshotRollup{Dataset: dataset}
+				rollups[dataset] = r
+			}
+			r.Add(d)
+			fn(d)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		datasets := make([]string, 0, len(rollups))
+		for dataset := range rollups {
+			datasets = append(datasets, dataset)
+		}
+		sort.Strings(datasets)
+		for _, dataset := range datasets {
+			publish(rollupEvent(rollups[dataset]))
+		}
+		return nil
+	})
+}
+
+func rollupEvent(r *SnapshotRollup) beat.Event {
+	prefixes := common.MapStr{}
+	for prefix, n := range r.Prefixes {
+		prefixes[prefix] = n
+	}
+
+	fields := common.MapStr{
+		"source":             "snapshot_rollup",
+		"dataset":            r.Dataset,
+		"snapshots.count":    r.Count,
+		"snapshots.oldest":   timeValue(r.Oldest),
+		"snapshots.newest":   timeValue(r.Newest),
+		"snapshots.used":     r.Used,
+		"snapshots.written":  r.Written,
+		"snapshots.prefixes": prefixes,
+	}
+	omitNil(fields)
+
+	return beat.Event{
+		Timestamp: time.Now(),
+		Fields:    fields,
+	}
+}
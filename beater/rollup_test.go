@@ -0,0 +1,63 @@
+// +build !integration
+
+package beater
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/common"
+
+	"github.com/maireanu/zfsbeat/config"
+)
+
+func TestCollectSnapshotRollup(t *testing.T) {
+	e := NewFakeExecutor()
+	e.On(FakeResult{Stdout: "tank\n"}, "zpool", "list", "-H", "-o", "name")
+	e.On(FakeResult{Stdout: "tank/home@zfs-auto-snap_hourly-2019-01-01-1200\t1546344000\t100\t1000\t0\n" +
+		"tank/home@zfs-auto-snap_hourly-2019-01-01-1300\t1546347600\t200\t2000\t0\n" +
+		"tank/home@zfs-auto-snap_daily-2019-01-01-0000\t1546300800\t300\t3000\t0\n" +
+		"tank/vm@manual\t1546387200\t5\t50\t0\n" +
+		"tank/vm@2019-01-02-000000\t1546387300\t5\t50\t0\n" +
+		"tank/vm@pre.upgrade-1\t1546387400\t5\t50\t0\n"},
+		"zfs", "list", "-rpH", "-t", "snapshot", "-o", strings.Join(rollupPropList, ","), "tank")
+
+	bt := newLocalZfsbeat(config.DefaultConfig, e)
+
+	var events []beat.Event
+	err := bt.collectSnapshots(context.Background(), bt.hosts[0], func(event beat.Event) {
+		events = append(events, event)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	home := events[0].Fields
+	if home["source"] != "snapshot_rollup" || home["dataset"] != "tank/home" || home["snapshots.count"] != 3 {
+		t.Errorf("unexpected event: %v", home)
+	}
+	if home["snapshots.oldest"] != time.Unix(1546300800, 0).UTC() || home["snapshots.newest"] != time.Unix(1546347600, 0).UTC() {
+		t.Errorf("unexpected creation times: %v", home)
+	}
+	if home["snapshots.used"] != uint64(600) || home["snapshots.written"] != uint64(6000) {
+		t.Errorf("unexpected sizes: %v", home)
+	}
+	expected := common.MapStr{"zfs-auto-snap_hourly": 2, "zfs-auto-snap_daily": 1}
+	if home["snapshots.prefixes"].(common.MapStr).String() != expected.String() {
+		t.Errorf("expected prefixes %v, got %v", expected, home["snapshots.prefixes"])
+	}
+	vm := events[1].Fields
+	if vm["dataset"] != "tank/vm" || vm["snapshots.count"] != 3 {
+		t.Errorf("unexpected event: %v", vm)
+	}
+	expected = common.MapStr{"manual": 1, "_none": 1, "pre_upgrade": 1}
+	if vm["snapshots.prefixes"].(common.MapStr).String() != expected.String() {
+		t.Errorf("expected prefixes %v, got %v", expected, vm["snapshots.prefixes"])
+	}
+}
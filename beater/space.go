@@ -0,0 +1,121 @@
+package beater
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// SpaceUsage is the space and number of objects a user, group or project uses
+// in a dataset, as reported by `zfs userspace`, `zfs groupspace` and
+// `zfs projectspace`. The quotas are nil when none is set.
+type SpaceUsage struct {
+	Dataset  string
+	Type     string
+	Name     string
+	Used     uint64
+	Quota    *uint64
+	ObjUsed  *uint64
+	ObjQuota *uint64
+}
+
+var spacePropList = []string{"type", "name", "used", "quota", "objused", "objquota"}
+
+// StreamSpace hands the space usage of every principal of kind (user, group
+// or project) in a dataset to fn. User and group IDs are resolved to names
+// when resolve is set; projects only have numeric IDs, and `zfs projectspace`
+// takes no -n. Listing stops at the first error returned by fn.
+func StreamSpace(ctx context.Context, e Executor, kind, dataset string, resolve bool, fn func(*SpaceUsage) error) error {
+	args := []string{kind + "space", "-Hp", "-o", strings.Join(spacePropList, ",")}
+	if !resolve && kind != "project" {
+		args = append(args, "-n")
+	}
+	args = append(args, dataset)
+
+	return e.Stream(ctx, func(line []string) error {
+		u := &SpaceUsage{Dataset: dataset}
+		if err := u.parseLine(line); err != nil {
+			return err
+		}
+		return fn(u)
+	}, "zfs", args...)
+}
+
+// parseLine sets the space usage from a `zfs userspace -Hp` output line,
+// whose columns are spacePropList.
+func (u *SpaceUsage) parseLine(line []string) error {
+	if len(line) != len(spacePropList) {
+		return fmt.Errorf("expected %d properties, got %d in %q", len(spacePropList), len(line), strings.Join(line, "\t"))
+	}
+
+	setString(&u.Type, line[0])
+	setString(&u.Name, line[1])
+	if err := setUint(&u.Used, line[2]); err != nil {
+		return err
+	}
+	if err := setOptUint(&u.Quota, line[3]); err != nil {
+		return err
+	}
+	if err := setOptUint(&u.ObjUsed, line[4]); err != nil {
+		return err
+	}
+	return setOptUint(&u.ObjQuota, line[5])
+}
+
+// collectSpace publishes one event per principal of the configured kinds in
+// every configured dataset.
+func (bt *Zfsbeat) collectSpace(ctx context.Context, h *host, publish func(beat.Event)) error {
+	c := bt.config.Space
+	for _, dataset := range c.Datasets {
+		if !bt.allowDataset(h, dataset) {
+			continue
+		}
+		for _, kind := range c.Types {
+			err := StreamSpace(ctx, h.executor, kind, dataset, c.ResolveNames, func(u *SpaceUsage) error {
+				publish(spaceEvent(kind, u))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func spaceEvent(kind string, u *SpaceUsage) beat.Event {
+	fields := common.MapStr{
+		"source":         "space",
+		"dataset":        u.Dataset,
+		"principal.kind": kind,
+		"principal.type": u.Type,
+		"principal.name": u.Name,
+		"used":           u.Used,
+		"quota":          uintValue(u.Quota),
+		"objused":        uintValue(u.ObjUsed),
+		"objquota":       uintValue(u.ObjQuota),
+		"used_pct":       quotaPercent(u.Used, u.Quota),
+	}
+	if u.ObjUsed != nil {
+		fields["objused_pct"] = quotaPercent(*u.ObjUsed, u.ObjQuota)
+	}
+	omitNil(fields)
+
+	return beat.Event{
+		Timestamp: time.Now(),
+		Fields:    fields,
+	}
+}
+
+// quotaPercent returns the percentage of the quota in use, or nil when no
+// quota is set.
+func quotaPercent(used uint64, quota *uint64) interface{} {
+	if quota == nil || *quota == 0 {
+		return nil
+	}
+	return float64(used) / float64(*quota) * 100
+}
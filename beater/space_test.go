@@ -0,0 +1,58 @@
+// +build !integration
+
+package beater
+
+import (
+	"context"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/beat"
+
+	"github.com/maireanu/zfsbeat/config"
+)
+
+func TestCollectSpace(t *testing.T) {
+	e := NewFakeExecutor()
+	e.On(FakeResult{Stdout: "POSIX User\t1000\t536870912\t1073741824\t1200\tnone\n" +
+		"POSIX User\t1001\t4096\tnone\t3\tnone\n"},
+		"zfs", "userspace", "-Hp", "-o", "type,name,used,quota,objused,objquota", "-n", "tank/home")
+	e.On(FakeResult{Stdout: "POSIX Group\t100\t536875008\tnone\t1203\t2406\n"},
+		"zfs", "groupspace", "-Hp", "-o", "type,name,used,quota,objused,objquota", "-n", "tank/home")
+	// zfs projectspace rejects -n.
+	e.On(FakeResult{Stdout: "POSIX Project\t42\t1048576\t4194304\t10\tnone\n"},
+		"zfs", "projectspace", "-Hp", "-o", "type,name,used,quota,objused,objquota", "tank/home")
+
+	c := config.DefaultConfig
+	c.Space.Datasets = []string{"tank/home"}
+	c.Space.Types = []string{"user", "group", "project"}
+	bt := newLocalZfsbeat(c, e)
+
+	var events []beat.Event
+	err := bt.collectSpace(context.Background(), bt.hosts[0], func(event beat.Event) {
+		events = append(events, event)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 4 {
+		t.Fatalf("expected 4 events, got %d", len(events))
+	}
+	alice := events[0].Fields
+	if alice["principal.kind"] != "user" || alice["principal.name"] != "1000" || alice["dataset"] != "tank/home" {
+		t.Errorf("unexpected event: %v", alice)
+	}
+	if alice["used"] != uint64(536870912) || alice["quota"] != uint64(1073741824) || alice["used_pct"] != 50.0 {
+		t.Errorf("unexpected usage: %v", alice)
+	}
+	if _, ok := events[1].Fields["used_pct"]; ok {
+		t.Errorf("expected no percentage without a quota: %v", events[1].Fields)
+	}
+	if events[2].Fields["objused_pct"] != 50.0 {
+		t.Errorf("unexpected object usage: %v", events[2].Fields)
+	}
+	project := events[3].Fields
+	if project["principal.kind"] != "project" || project["principal.name"] != "42" || project["used_pct"] != 25.0 {
+		t.Errorf("unexpected event: %v", project)
+	}
+}
@@ -0,0 +1,69 @@
+package beater
+
+import (
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/maireanu/zfsbeat/config"
+)
+
+// NewSSHExecutor returns an Executor which runs the commands on a remote host
+// through the ssh client, authenticating with the key in keyFile. The binary
+// paths and command prefix from the config apply on the remote host.
+func NewSSHExecutor(c config.Config, h config.Host, keyFile string) Executor {
+	ssh := []string{c.SSHPath, "-o", "BatchMode=yes"}
+	if keyFile != "" {
+		ssh = append(ssh, "-i", keyFile, "-o", "IdentitiesOnly=yes")
+	}
+	if h.KnownHostsFile != "" {
+		ssh = append(ssh, "-o", "UserKnownHostsFile="+h.KnownHostsFile)
+	}
+	if h.Port != 0 {
+		ssh = append(ssh, "-p", strconv.Itoa(h.Port))
+	}
+	if h.User != "" {
+		ssh = append(ssh, "-l", h.User)
+	}
+	ssh = append(ssh, h.Address, "--")
+
+	e := NewExecutor(c).(execExecutor)
+	e.ssh = ssh
+	return e
+}
+
+// writePrivateKey writes a private key, usually read from the keystore, to a
+// file only readable by the beat, so it can be handed to the ssh client.
+func writePrivateKey(key string) (string, error) {
+	f, err := ioutil.TempFile("", "zfsbeat-key-")
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.HasSuffix(key, "\n") {
+		key += "\n"
+	}
+	_, err = f.WriteString(key)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// Don't leave part of the key behind.
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// shellQuote quotes an argument for the remote shell which ssh passes the
+// command line to.
+func shellQuote(s string) string {
+	if shellSafe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'"'"'`, -1) + "'"
+}
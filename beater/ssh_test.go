@@ -0,0 +1,42 @@
+// +build !integration
+
+package beater
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/maireanu/zfsbeat/config"
+)
+
+func TestSSHExecutorCommand(t *testing.T) {
+	c := config.DefaultConfig
+	c.CommandPrefix = []string{"sudo", "-n"}
+	h := config.Host{Address: "nas1.example.com", Port: 2222, User: "zfsbeat"}
+
+	e := NewSSHExecutor(c, h, "/tmp/key").(execExecutor)
+	cmd, args := e.command("zfs", []string{"list", "-H", "tank/my data"})
+
+	expected := []string{
+		"-o", "BatchMode=yes", "-i", "/tmp/key", "-o", "IdentitiesOnly=yes",
+		"-p", "2222", "-l", "zfsbeat", "nas1.example.com", "--",
+		"sudo", "-n", "zfs", "list", "-H", "'tank/my data'",
+	}
+	if cmd.Command != "ssh" || !reflect.DeepEqual(args, expected) {
+		t.Errorf("unexpected command: %s %v", cmd.Command, args)
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	cases := map[string]string{
+		"tank/home@daily": "tank/home@daily",
+		"name,used":       "name,used",
+		"my data":         "'my data'",
+		"it's":            `'it'"'"'s'`,
+	}
+	for in, expected := range cases {
+		if out := shellQuote(in); out != expected {
+			t.Errorf("shellQuote(%q) = %q, expected %q", in, out, expected)
+		}
+	}
+}
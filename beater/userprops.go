@@ -0,0 +1,23 @@
+package beater
+
+import (
+	"path"
+)
+
+// matchUserProperty reports whether a user property is included and not
+// excluded by the configured patterns.
+func (bt *Zfsbeat) matchUserProperty(prop string) bool {
+	c := bt.config.UserProperties
+	return (len(c.Include) == 0 || matchAny(c.Include, prop)) && !matchAny(c.Exclude, prop)
+}
+
+// matchAny reports whether name matches any of the glob patterns, which are
+// validated with the config.
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
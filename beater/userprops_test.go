@@ -0,0 +1,50 @@
+// +build !integration
+
+package beater
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/maireanu/zfsbeat/config"
+)
+
+func TestStreamUserProperties(t *testing.T) {
+	e := NewFakeExecutor()
+	e.On(FakeResult{Stdout: "tank/home@a\tcompression\tlz4\tinherited from tank\n" +
+		"tank/home@a\torg.example:owner\talice\tinherited from tank/home\n" +
+		"tank/home@a\torg.example:costcenter\t42\tinherited from tank/home\n" +
+		"tank/home@a\tcom.sun:auto-snapshot\ttrue\tlocal\n" +
+		"tank/home@a\torg.example:unset\t-\t-\n" +
+		"tank/vm@b\tcom.sun:auto-snapshot\tfalse\treceived\n",
+	}, "zfs", "get", "-rHp", "-t", "snapshot", "-o", "name,property,value,source", "all", "tank")
+
+	c := config.DefaultConfig
+	c.UserProperties = config.UserProperties{
+		Enabled: true,
+		Include: []string{"org.example:*", "com.sun:*"},
+		Exclude: []string{"org.example:costcenter"},
+	}
+	bt := newZfsbeat(c)
+
+	props := map[string]map[string]string{}
+	err := streamByGet(context.Background(), e, DatasetSnapshot, "tank", []string{"compression"}, false, bt.matchUserProperty, func(d *Dataset) error {
+		props[d.Name] = d.UserProperties
+		if d.Sources != nil {
+			t.Errorf("expected no property sources, got %v", d.Sources)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]map[string]string{
+		"tank/home@a": {"org.example:owner": "alice", "com.sun:auto-snapshot": "true"},
+		"tank/vm@b":   {"com.sun:auto-snapshot": "false"},
+	}
+	if !reflect.DeepEqual(props, expected) {
+		t.Errorf("expected %v, got %v", expected, props)
+	}
+}
@@ -1,11 +1,15 @@
 package beater
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"fmt"
 	"io"
 	"os/exec"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type command struct {
@@ -14,9 +18,12 @@ type command struct {
 	Stdout  io.Writer
 }
 
-func (c *command) Run(arg ...string) ([][]string, error) {
+// Run executes the command and waits for it to exit. When ctx is done before
+// that, the whole process group of the command is killed.
+func (c *command) Run(ctx context.Context, arg ...string) ([][]string, error) {
 
 	cmd := exec.Command(c.Command, arg...)
+	setProcessGroup(cmd)
 
 	var stdout, stderr bytes.Buffer
 
@@ -34,15 +41,13 @@ func (c *command) Run(arg ...string) ([][]string, error) {
 
 	joinedArgs := strings.Join(cmd.Args, " ")
 
-	err := cmd.Run()
+	err := cmd.Start()
+	if err == nil {
+		err = wait(ctx, cmd)
+	}
 
 	if err != nil {
-		return nil, &Error{
-			Err: err,
-			//Debug:  strings.Join([]string{cmd.Path, joinedArgs[1:]}, " "),
-			Debug:  strings.Join([]string{joinedArgs}, " "),
-			Stderr: stderr.String(),
-		}
+		return nil, newError(err, exitCode(err), joinedArgs, stderr.String())
 	}
 
 	// assume if you passed in something for stdout, that you know what to do with it
@@ -50,17 +55,115 @@ func (c *command) Run(arg ...string) ([][]string, error) {
 		return nil, nil
 	}
 
-	lines := strings.Split(stdout.String(), "\n")
+	return splitOutput(stdout.String()), nil
+}
+
+// Stream executes the command and hands every line of its stdout to fn while
+// the command is still running. When ctx is done or fn returns an error, the
+// whole process group of the command is killed.
+func (c *command) Stream(ctx context.Context, fn func(fields []string) error, arg ...string) error {
+
+	cmd := exec.Command(c.Command, arg...)
+	setProcessGroup(cmd)
+
+	var stderr bytes.Buffer
+	cmd.Stdin = c.Stdin
+	cmd.Stderr = &stderr
+
+	joinedArgs := strings.Join(cmd.Args, " ")
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return newError(err, -1, joinedArgs, "")
+	}
+	if err := cmd.Start(); err != nil {
+		return newError(err, exitCode(err), joinedArgs, stderr.String())
+	}
+
+	// The command can't be waited on before stdout is read to the end, so
+	// it is killed from here when ctx is done while reading.
+	stopWatch := make(chan struct{})
+	watchDone := make(chan struct{})
+	go func() {
+		defer close(watchDone)
+		select {
+		case <-ctx.Done():
+			killProcessGroup(cmd)
+		case <-stopWatch:
+		}
+	}()
+
+	var fnErr error
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		if fnErr = fn(splitLine(scanner.Text())); fnErr != nil {
+			break
+		}
+	}
+	if fnErr == nil {
+		fnErr = scanner.Err()
+	}
+	if fnErr != nil {
+		killProcessGroup(cmd)
+	}
+
+	close(stopWatch)
+	<-watchDone
+
+	err = wait(ctx, cmd)
+	if fnErr != nil {
+		return fnErr
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return newError(err, exitCode(err), joinedArgs, stderr.String())
+	}
+	return nil
+}
+
+// maxLineSize is the longest stdout line Stream accepts.
+const maxLineSize = 1024 * 1024
+
+// wait waits for a started command to exit. When ctx is done before that, the
+// whole process group of the command is killed.
+func wait(ctx context.Context, cmd *exec.Cmd) error {
+	waitc := make(chan error, 1)
+	go func() { waitc <- cmd.Wait() }()
+
+	select {
+	case err := <-waitc:
+		return err
+	case <-ctx.Done():
+		killProcessGroup(cmd)
+		<-waitc
+		return ctx.Err()
+	}
+}
+
+func splitOutput(out string) [][]string {
+	lines := strings.Split(out, "\n")
 
 	//last line is always blank
 	lines = lines[0 : len(lines)-1]
 	output := make([][]string, len(lines))
 
 	for i, l := range lines {
-		output[i] = strings.Fields(l)
+		output[i] = splitLine(l)
 	}
 
-	return output, nil
+	return output
+}
+
+// splitLine splits an output line into its fields. The scripted (-H) output
+// of zfs and zpool is tab separated, so values may contain spaces.
+func splitLine(l string) []string {
+	if strings.Contains(l, "\t") {
+		return strings.Split(l, "\t")
+	}
+	return strings.Fields(l)
 }
 
 func setString(field *string, value string) {
@@ -83,3 +186,69 @@ func setUint(field *uint64, value string) error {
 	*field = v
 	return nil
 }
+
+// isNull reports whether a property value means the property is unset or
+// doesn't apply to the dataset.
+func isNull(value string) bool {
+	return value == "-" || value == "none" || value == ""
+}
+
+func setOptUint(field **uint64, value string) error {
+	if isNull(value) {
+		*field = nil
+		return nil
+	}
+	var v uint64
+	if err := setUint(&v, value); err != nil {
+		return err
+	}
+	*field = &v
+	return nil
+}
+
+func setOptFloat(field **float64, value string) error {
+	if isNull(value) {
+		*field = nil
+		return nil
+	}
+	v, err := strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+	if err != nil {
+		return err
+	}
+	*field = &v
+	return nil
+}
+
+func setOptBool(field **bool, value string) error {
+	var v bool
+	switch value {
+	case "on", "yes":
+		v = true
+	case "off", "no":
+		v = false
+	default:
+		if isNull(value) {
+			*field = nil
+			return nil
+		}
+		return fmt.Errorf("invalid boolean property value %q", value)
+	}
+	*field = &v
+	return nil
+}
+
+// setOptTime parses a time property, which `zfs list -p` prints as seconds
+// since the epoch.
+func setOptTime(field **time.Time, value string) error {
+	if isNull(value) {
+		*field = nil
+		return nil
+	}
+	var sec uint64
+	if err := setUint(&sec, value); err != nil {
+		return err
+	}
+	v := time.Unix(int64(sec), 0).UTC()
+	*field = &v
+	return nil
+}
@@ -0,0 +1,70 @@
+// +build !integration,!windows
+
+package beater
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestCommandRunKillsProcessGroup(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	c := command{Command: "sh"}
+	_, err := c.Run(ctx, "-c", "sleep 30 & sleep 30")
+	if time.Since(start) > 10*time.Second {
+		t.Fatal("command was not killed")
+	}
+
+	zerr, ok := err.(*Error)
+	if !ok || !zerr.Timeout() {
+		t.Fatalf("expected a timeout error, got %v", err)
+	}
+}
+
+func TestCommandStream(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+
+	var lines [][]string
+	c := command{Command: "sh"}
+	err := c.Stream(context.Background(), func(fields []string) error {
+		lines = append(lines, fields)
+		return nil
+	}, "-c", "printf 'tank\t1\ntank/home\t2\n'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 || lines[1][0] != "tank/home" || lines[1][1] != "2" {
+		t.Errorf("unexpected lines: %v", lines)
+	}
+}
+
+func TestCommandStreamStopsOnError(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+
+	stop := errors.New("stop")
+	start := time.Now()
+	c := command{Command: "sh"}
+	err := c.Stream(context.Background(), func(fields []string) error {
+		return stop
+	}, "-c", "echo first; sleep 30")
+	if err != stop {
+		t.Fatalf("expected the error of fn, got %v", err)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Fatal("command was not killed")
+	}
+}
@@ -1,7 +1,10 @@
 package beater
 
 import (
+	"context"
+	"fmt"
 	"strings"
+	"time"
 )
 
 // ZFS dataset types, which can indicate if a dataset is a filesystem,
@@ -12,136 +15,163 @@ const (
 	DatasetVolume     = "volume"
 )
 
+// KeyUnavailable is the keystatus of an encrypted dataset whose key isn't
+// loaded.
+const KeyUnavailable = "unavailable"
+
 // Dataset is a ZFS dataset.  A dataset could be a clone, filesystem, snapshot,
 // or volume.  The Type struct member can be used to determine a dataset's type.
 //
+// Sizes are in bytes, ratios are plain factors and on/off or yes/no
+// properties are booleans. Those fields are nil when the property doesn't
+// apply to the dataset, e.g. `available` of a snapshot. UserProperties holds
+// the user properties (module:property) and Sources where the property values
+// come from, when they are collected.
+//
 // The field definitions can be found in the ZFS manual:
 // http://www.freebsd.org/cgi/man.cgi?zfs(8).
 type Dataset struct {
 	Name                 string
-	Available            string
+	Available            *uint64
 	Clones               string
-	Compressratio        string
-	Creation             string
-	DeferDestroy         string
-	Logicalreferenced    string
-	Logicalused          string
-	Mounted              string
+	Compressratio        *float64
+	Creation             *time.Time
+	DeferDestroy         *bool
+	Logicalreferenced    *uint64
+	Logicalused          *uint64
+	Mounted              *bool
 	Origin               string
-	Refcompressratio     string
-	Referenced           string
+	Refcompressratio     *float64
+	Referenced           *uint64
 	Type                 string
-	Used                 string
-	Usedbychildren       string
-	Usedbydataset        string
-	Usedbyrefreservation string
-	Usedbysnapshots      string
-	Userrefs             string
-	Written              string
+	Used                 *uint64
+	Usedbychildren       *uint64
+	Usedbydataset        *uint64
+	Usedbyrefreservation *uint64
+	Usedbysnapshots      *uint64
+	Userrefs             *uint64
+	Written              *uint64
+	GUID                 string
 	Aclinherit           string
 	Acltype              string
-	Atime                string
+	Atime                *bool
 	Canmount             string
 	Casesensitivity      string
 	Checksum             string
 	Compression          string
 	Context              string
-	Copies               string
+	Copies               *uint64
 	Dedup                string
 	Defcontext           string
-	Devices              string
-	Exec                 string
-	FilesystemCount      string
-	FilesystemLimit      string
+	Devices              *bool
+	Encryption           string
+	Encryptionroot       string
+	Exec                 *bool
+	FilesystemCount      *uint64
+	FilesystemLimit      *uint64
 	Fscontext            string
+	Keyformat            string
+	Keylocation          string
+	Keystatus            string
 	Logbias              string
 	Mlslabel             string
 	Mountpoint           string
-	Nbmand               string
+	Nbmand               *bool
 	Normalization        string
-	Overlay              string
+	Overlay              *bool
+	Pbkdf2iters          *uint64
 	Primarycache         string
-	Quota                string
-	Readonly             string
-	Recordsize           string
+	Quota                *uint64
+	Readonly             *bool
+	ReceiveResumeToken   string
+	Recordsize           *uint64
 	RedundantMetadata    string
-	Refquota             string
-	Refreservation       string
-	Relatime             string
-	Reservation          string
+	Refquota             *uint64
+	Refreservation       *uint64
+	Relatime             *bool
+	Reservation          *uint64
 	Rootcontext          string
 	Secondarycache       string
-	Setuid               string
+	Setuid               *bool
 	Sharenfs             string
 	Sharesmb             string
 	Snapdev              string
 	Snapdir              string
-	SnapshotCount        string
-	SnapshotLimit        string
+	SnapshotCount        *uint64
+	SnapshotLimit        *uint64
 	Sync                 string
-	Utf8only             string
-	Version              string
-	Volblocksize         string
-	Volsize              string
-	Vscan                string
+	Utf8only             *bool
+	Version              *uint64
+	Volblocksize         *uint64
+	Volsize              *uint64
+	Vscan                *bool
 	Xattr                string
-	Zoned                string
+	Zoned                *bool
+	Properties           map[string]string
+	UserProperties       map[string]string
+	Sources              map[string]PropertySource
 }
 
-var dsPropList = []string{"name", "available", "clones", "compressratio", "creation", "defer_destroy", "logicalreferenced", "logicalused", "mounted", "origin", "refcompressratio", "referenced", "type", "used", "usedbychildren", "usedbydataset", "usedbyrefreservation", "usedbysnapshots", "userrefs", "written", "aclinherit", "acltype", "atime", "canmount", "casesensitivity", "checksum", "compression", "context", "copies", "dedup", "defcontext", "devices", "exec", "filesystem_count", "filesystem_limit", "fscontext", "logbias", "mlslabel", "mountpoint", "nbmand", "normalization", "overlay", "primarycache", "quota", "readonly", "recordsize", "redundant_metadata", "refquota", "refreservation", "relatime", "reservation", "rootcontext", "secondarycache", "setuid", "sharenfs", "sharesmb", "snapdev", "snapdir", "snapshot_count", "snapshot_limit", "sync", "utf8only", "version", "volblocksize", "volsize", "vscan", "xattr", "zoned"}
+var dsPropList = []string{"name", "available", "clones", "compressratio", "creation", "defer_destroy", "logicalreferenced", "logicalused", "mounted", "origin", "refcompressratio", "referenced", "type", "used", "usedbychildren", "usedbydataset", "usedbyrefreservation", "usedbysnapshots", "userrefs", "written", "aclinherit", "acltype", "atime", "canmount", "casesensitivity", "checksum", "compression", "context", "copies", "dedup", "defcontext", "devices", "encryption", "encryptionroot", "exec", "filesystem_count", "filesystem_limit", "fscontext", "keyformat", "keylocation", "keystatus", "logbias", "mlslabel", "mountpoint", "nbmand", "normalization", "overlay", "pbkdf2iters", "primarycache", "quota", "readonly", "recordsize", "redundant_metadata", "refquota", "refreservation", "relatime", "reservation", "rootcontext", "secondarycache", "setuid", "sharenfs", "sharesmb", "snapdev", "snapdir", "snapshot_count", "snapshot_limit", "sync", "utf8only", "version", "volblocksize", "volsize", "vscan", "xattr", "zoned"}
 var dsPropListOptions = strings.Join(dsPropList, ",")
 
-func zfs(arg ...string) ([][]string, error) {
-	c := command{Command: "zfs"}
-	return c.Run(arg...)
+// zfs is a helper function to wrap typical calls to zfs.
+func zfs(ctx context.Context, e Executor, arg ...string) ([][]string, error) {
+	return e.Run(ctx, "zfs", arg...)
 }
 
 // Datasets returns a slice of ZFS datasets, regardless of type.
 // A filter argument may be passed to select a dataset with the matching name,
-// or empty string ("") may be used to select all datasets.
-func Datasets(filter string) ([]*Dataset, error) {
-	return listByType("all", filter)
+// or empty string ("") may be used to select all datasets. The given
+// properties are listed, such as those discovered on the host, or dsPropList
+// when props is nil.
+func Datasets(ctx context.Context, e Executor, filter string, props []string) ([]*Dataset, error) {
+	return listByType(ctx, e, "all", filter, props)
 }
 
 // Filesystems returns a slice of ZFS filesystems.
 // A filter argument may be passed to select a filesystem with the matching name,
 // or empty string ("") may be used to select all filesystems.
-func Filesystems(filter string) ([]*Dataset, error) {
-	return listByType(DatasetFilesystem, filter)
+func Filesystems(ctx context.Context, e Executor, filter string, props []string) ([]*Dataset, error) {
+	return listByType(ctx, e, DatasetFilesystem, filter, props)
 }
 
 // Volumes returns a slice of ZFS volumes.
 // A filter argument may be passed to select a volume with the matching name,
 // or empty string ("") may be used to select all volumes.
-func Volumes(filter string) ([]*Dataset, error) {
-	return listByType(DatasetVolume, filter)
+func Volumes(ctx context.Context, e Executor, filter string, props []string) ([]*Dataset, error) {
+	return listByType(ctx, e, DatasetVolume, filter, props)
 }
 
 // Snapshots returns a slice of ZFS snapshots.
 // A filter argument may be passed to select a snapshot with the matching name,
 // or empty string ("") may be used to select all snapshots.
-func Snapshots(filter string) ([]*Dataset, error) {
-	return listByType(DatasetSnapshot, filter)
+func Snapshots(ctx context.Context, e Executor, filter string, props []string) ([]*Dataset, error) {
+	return listByType(ctx, e, DatasetSnapshot, filter, props)
 }
 
 // Snapshots returns a slice of all ZFS snapshots of a given dataset.
-func (d *Dataset) Snapshots() ([]*Dataset, error) {
-	return Snapshots(d.Name)
+func (d *Dataset) Snapshots(ctx context.Context, e Executor, props []string) ([]*Dataset, error) {
+	return Snapshots(ctx, e, d.Name, props)
 }
 
 // GetProperty returns the current value of a ZFS property from the
 
 // GetDataset retrieves a single ZFS dataset by name.  This dataset could be
 // any valid ZFS dataset type, such as a clone, filesystem, snapshot, or volume.
-func GetDataset(name string) (*Dataset, error) {
-	out, err := zfs("list", "-Hp", "-o", dsPropListOptions, name)
+// The given properties are listed, or dsPropList when props is nil.
+func GetDataset(ctx context.Context, e Executor, name string, props []string) (*Dataset, error) {
+	if props == nil {
+		props = dsPropList
+	}
+	out, err := zfs(ctx, e, "list", "-Hp", "-o", strings.Join(props, ","), name)
 	if err != nil {
 		return nil, err
 	}
 
 	ds := &Dataset{Name: name}
 	for _, line := range out {
-		if err := ds.parseLine(line); err != nil {
+		if err := ds.parseLine(props, line); err != nil {
 			return nil, err
 		}
 	}
@@ -149,13 +179,16 @@ func GetDataset(name string) (*Dataset, error) {
 	return ds, nil
 }
 
-func listByType(t, filter string) ([]*Dataset, error) {
-	args := []string{"list", "-rpH", "-t", t, "-o", dsPropListOptions}
+func listByType(ctx context.Context, e Executor, t, filter string, props []string) ([]*Dataset, error) {
+	if props == nil {
+		props = dsPropList
+	}
+	args := []string{"list", "-rpH", "-t", t, "-o", strings.Join(props, ",")}
 
 	if filter != "" {
 		args = append(args, filter)
 	}
-	out, err := zfs(args...)
+	out, err := zfs(ctx, e, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -170,7 +203,7 @@ func listByType(t, filter string) ([]*Dataset, error) {
 			ds = &Dataset{Name: name}
 			datasets = append(datasets, ds)
 		}
-		if err := ds.parseLine(line); err != nil {
+		if err := ds.parseLine(props, line); err != nil {
 			return nil, err
 		}
 	}
@@ -178,75 +211,270 @@ func listByType(t, filter string) ([]*Dataset, error) {
 	return datasets, nil
 }
 
-func (d *Dataset) parseLine(line []string) error {
-
-	setString(&d.Name, line[0])
-	setString(&d.Available, line[1])
-	setString(&d.Clones, line[2])
-	setString(&d.Compressratio, line[3])
-	setString(&d.Creation, line[4])
-	setString(&d.DeferDestroy, line[5])
-	setString(&d.Logicalreferenced, line[6])
-	setString(&d.Logicalused, line[7])
-	setString(&d.Mounted, line[8])
-	setString(&d.Origin, line[9])
-	setString(&d.Refcompressratio, line[10])
-	setString(&d.Referenced, line[11])
-	setString(&d.Type, line[12])
-	setString(&d.Used, line[13])
-	setString(&d.Usedbychildren, line[14])
-	setString(&d.Usedbydataset, line[15])
-	setString(&d.Usedbyrefreservation, line[16])
-	setString(&d.Usedbysnapshots, line[17])
-	setString(&d.Userrefs, line[18])
-	setString(&d.Written, line[19])
-	setString(&d.Aclinherit, line[20])
-	setString(&d.Acltype, line[21])
-	setString(&d.Atime, line[22])
-	setString(&d.Canmount, line[23])
-	setString(&d.Casesensitivity, line[24])
-	setString(&d.Checksum, line[25])
-	setString(&d.Compression, line[26])
-	setString(&d.Context, line[27])
-	setString(&d.Copies, line[28])
-	setString(&d.Dedup, line[29])
-	setString(&d.Defcontext, line[30])
-	setString(&d.Devices, line[31])
-	setString(&d.Exec, line[32])
-	setString(&d.FilesystemCount, line[33])
-	setString(&d.FilesystemLimit, line[34])
-	setString(&d.Fscontext, line[35])
-	setString(&d.Logbias, line[36])
-	setString(&d.Mlslabel, line[37])
-	setString(&d.Mountpoint, line[38])
-	setString(&d.Nbmand, line[39])
-	setString(&d.Normalization, line[40])
-	setString(&d.Overlay, line[41])
-	setString(&d.Primarycache, line[42])
-	setString(&d.Quota, line[43])
-	setString(&d.Readonly, line[44])
-	setString(&d.Recordsize, line[45])
-	setString(&d.RedundantMetadata, line[46])
-	setString(&d.Refquota, line[47])
-	setString(&d.Refreservation, line[48])
-	setString(&d.Relatime, line[49])
-	setString(&d.Reservation, line[50])
-	setString(&d.Rootcontext, line[51])
-	setString(&d.Secondarycache, line[52])
-	setString(&d.Setuid, line[53])
-	setString(&d.Sharenfs, line[54])
-	setString(&d.Sharesmb, line[55])
-	setString(&d.Snapdev, line[56])
-	setString(&d.Snapdir, line[57])
-	setString(&d.SnapshotCount, line[58])
-	setString(&d.SnapshotLimit, line[59])
-	setString(&d.Sync, line[60])
-	setString(&d.Utf8only, line[61])
-	setString(&d.Version, line[62])
-	setString(&d.Volblocksize, line[63])
-	setString(&d.Volsize, line[64])
-	setString(&d.Vscan, line[65])
-	setString(&d.Xattr, line[66])
+// parseLine sets the properties of the dataset from a `zfs list -Hp` output
+// line, whose columns are the given properties.
+func (d *Dataset) parseLine(props []string, line []string) error {
+	if len(line) != len(props) {
+		return fmt.Errorf("expected %d properties, got %d in %q", len(props), len(line), strings.Join(line, "\t"))
+	}
 
+	for i, prop := range props {
+		if err := d.setProperty(prop, line[i]); err != nil {
+			return fmt.Errorf("invalid %s of %s: %v", prop, line[0], err)
+		}
+	}
 	return nil
 }
+
+// setProperty sets a single property of the dataset by name. Properties
+// without a Dataset field are kept in Properties, so the ones added by newer
+// ZFS releases are published as well.
+func (d *Dataset) setProperty(prop, value string) error {
+	var err error
+
+	switch prop {
+	case "name":
+		setString(&d.Name, value)
+	case "available":
+		err = setOptUint(&d.Available, value)
+	case "clones":
+		setString(&d.Clones, value)
+	case "compressratio":
+		err = setOptFloat(&d.Compressratio, value)
+	case "creation":
+		err = setOptTime(&d.Creation, value)
+	case "defer_destroy":
+		err = setOptBool(&d.DeferDestroy, value)
+	case "logicalreferenced":
+		err = setOptUint(&d.Logicalreferenced, value)
+	case "logicalused":
+		err = setOptUint(&d.Logicalused, value)
+	case "mounted":
+		err = setOptBool(&d.Mounted, value)
+	case "origin":
+		setString(&d.Origin, value)
+	case "refcompressratio":
+		err = setOptFloat(&d.Refcompressratio, value)
+	case "referenced":
+		err = setOptUint(&d.Referenced, value)
+	case "type":
+		setString(&d.Type, value)
+	case "used":
+		err = setOptUint(&d.Used, value)
+	case "usedbychildren":
+		err = setOptUint(&d.Usedbychildren, value)
+	case "usedbydataset":
+		err = setOptUint(&d.Usedbydataset, value)
+	case "usedbyrefreservation":
+		err = setOptUint(&d.Usedbyrefreservation, value)
+	case "usedbysnapshots":
+		err = setOptUint(&d.Usedbysnapshots, value)
+	case "userrefs":
+		err = setOptUint(&d.Userrefs, value)
+	case "written":
+		err = setOptUint(&d.Written, value)
+	case "guid":
+		setString(&d.GUID, value)
+	case "aclinherit":
+		setString(&d.Aclinherit, value)
+	case "acltype":
+		setString(&d.Acltype, value)
+	case "atime":
+		err = setOptBool(&d.Atime, value)
+	case "canmount":
+		setString(&d.Canmount, value)
+	case "casesensitivity":
+		setString(&d.Casesensitivity, value)
+	case "checksum":
+		setString(&d.Checksum, value)
+	case "compression":
+		setString(&d.Compression, value)
+	case "context":
+		setString(&d.Context, value)
+	case "copies":
+		err = setOptUint(&d.Copies, value)
+	case "dedup":
+		setString(&d.Dedup, value)
+	case "defcontext":
+		setString(&d.Defcontext, value)
+	case "devices":
+		err = setOptBool(&d.Devices, value)
+	case "encryption":
+		setString(&d.Encryption, value)
+	case "encryptionroot":
+		setString(&d.Encryptionroot, value)
+	case "exec":
+		err = setOptBool(&d.Exec, value)
+	case "filesystem_count":
+		err = setOptUint(&d.FilesystemCount, value)
+	case "filesystem_limit":
+		err = setOptUint(&d.FilesystemLimit, value)
+	case "fscontext":
+		setString(&d.Fscontext, value)
+	case "keyformat":
+		setString(&d.Keyformat, value)
+	case "keylocation":
+		setString(&d.Keylocation, value)
+	case "keystatus":
+		setString(&d.Keystatus, value)
+	case "logbias":
+		setString(&d.Logbias, value)
+	case "mlslabel":
+		setString(&d.Mlslabel, value)
+	case "mountpoint":
+		setString(&d.Mountpoint, value)
+	case "nbmand":
+		err = setOptBool(&d.Nbmand, value)
+	case "normalization":
+		setString(&d.Normalization, value)
+	case "overlay":
+		err = setOptBool(&d.Overlay, value)
+	case "pbkdf2iters":
+		err = setOptUint(&d.Pbkdf2iters, value)
+	case "primarycache":
+		setString(&d.Primarycache, value)
+	case "quota":
+		err = setOptUint(&d.Quota, value)
+	case "readonly":
+		err = setOptBool(&d.Readonly, value)
+	case "receive_resume_token":
+		setString(&d.ReceiveResumeToken, value)
+	case "recordsize":
+		err = setOptUint(&d.Recordsize, value)
+	case "redundant_metadata":
+		setString(&d.RedundantMetadata, value)
+	case "refquota":
+		err = setOptUint(&d.Refquota, value)
+	case "refreservation":
+		err = setOptUint(&d.Refreservation, value)
+	case "relatime":
+		err = setOptBool(&d.Relatime, value)
+	case "reservation":
+		err = setOptUint(&d.Reservation, value)
+	case "rootcontext":
+		setString(&d.Rootcontext, value)
+	case "secondarycache":
+		setString(&d.Secondarycache, value)
+	case "setuid":
+		err = setOptBool(&d.Setuid, value)
+	case "sharenfs":
+		setString(&d.Sharenfs, value)
+	case "sharesmb":
+		setString(&d.Sharesmb, value)
+	case "snapdev":
+		setString(&d.Snapdev, value)
+	case "snapdir":
+		setString(&d.Snapdir, value)
+	case "snapshot_count":
+		err = setOptUint(&d.SnapshotCount, value)
+	case "snapshot_limit":
+		err = setOptUint(&d.SnapshotLimit, value)
+	case "sync":
+		setString(&d.Sync, value)
+	case "utf8only":
+		err = setOptBool(&d.Utf8only, value)
+	case "version":
+		err = setOptUint(&d.Version, value)
+	case "volblocksize":
+		err = setOptUint(&d.Volblocksize, value)
+	case "volsize":
+		err = setOptUint(&d.Volsize, value)
+	case "vscan":
+		err = setOptBool(&d.Vscan, value)
+	case "xattr":
+		setString(&d.Xattr, value)
+	case "zoned":
+		err = setOptBool(&d.Zoned, value)
+	default:
+		if !isNull(value) {
+			if d.Properties == nil {
+				d.Properties = map[string]string{}
+			}
+			d.Properties[prop] = value
+		}
+	}
+	return err
+}
+
+func streamByType(ctx context.Context, e Executor, t, filter string, props []string, fn func(*Dataset) error) error {
+	if props == nil {
+		props = dsPropList
+	}
+	args := []string{"list", "-rpH", "-t", t, "-o", strings.Join(props, ",")}
+
+	if filter != "" {
+		args = append(args, filter)
+	}
+
+	return e.Stream(ctx, func(line []string) error {
+		ds := &Dataset{Name: line[0]}
+		if err := ds.parseLine(props, line); err != nil {
+			return err
+		}
+		return fn(ds)
+	}, "zfs", args...)
+}
+
+// streamByGet streams the datasets of type t under filter like streamByType,
+// but from a single `zfs get`, which also prints where every property value
+// comes from and the user properties. When sources is set, the source of
+// every listed property is kept in Sources; read-only properties, which have
+// none, are left out. The user properties for which match returns true are
+// kept in UserProperties, unless match is nil. zfs get prints the properties
+// of a dataset together, so only one dataset is held at a time.
+func streamByGet(ctx context.Context, e Executor, t, filter string, props []string, sources bool, match func(prop string) bool, fn func(*Dataset) error) error {
+	if props == nil {
+		props = dsPropList
+	}
+	listed := make(map[string]bool, len(props))
+	for _, prop := range props {
+		listed[prop] = true
+	}
+
+	args := []string{"get", "-rHp", "-t", t, "-o", "name,property,value,source", "all"}
+	if filter != "" {
+		args = append(args, filter)
+	}
+
+	var ds *Dataset
+	err := e.Stream(ctx, func(line []string) error {
+		if len(line) < 4 {
+			return fmt.Errorf("expected 4 columns, got %d in %q", len(line), strings.Join(line, "\t"))
+		}
+		if ds != nil && ds.Name != line[0] {
+			if err := fn(ds); err != nil {
+				return err
+			}
+			ds = nil
+		}
+		if ds == nil {
+			ds = &Dataset{Name: line[0]}
+		}
+
+		prop, value, source := line[1], line[2], line[3]
+		if !listed[prop] {
+			// User properties never have the default source.
+			if match != nil && strings.Contains(prop, ":") && !isNull(source) && source != SourceDefault && match(prop) {
+				if ds.UserProperties == nil {
+					ds.UserProperties = map[string]string{}
+				}
+				ds.UserProperties[prop] = value
+			}
+			return nil
+		}
+		if err := ds.setProperty(prop, value); err != nil {
+			return fmt.Errorf("invalid %s of %s: %v", prop, ds.Name, err)
+		}
+		if sources && !isNull(source) {
+			if ds.Sources == nil {
+				ds.Sources = map[string]PropertySource{}
+			}
+			ds.Sources[prop] = parsePropertySource(source)
+		}
+		return nil
+	}, "zfs", args...)
+	if err != nil || ds == nil {
+		return err
+	}
+	return fn(ds)
+}
@@ -0,0 +1,113 @@
+// +build !integration
+
+package beater
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// datasetLine builds a `zfs list -Hp -o dsPropListOptions` output line with
+// every property set to "-" except the given ones.
+func datasetLine(props map[string]string) string {
+	fields := make([]string, len(dsPropList))
+	for i, p := range dsPropList {
+		fields[i] = "-"
+		if v, ok := props[p]; ok {
+			fields[i] = v
+		}
+	}
+	return strings.Join(fields, "\t") + "\n"
+}
+
+func TestFilesystems(t *testing.T) {
+	e := NewFakeExecutor()
+	e.On(FakeResult{
+		Stdout: datasetLine(map[string]string{"name": "tank", "type": "filesystem", "used": "1024", "mountpoint": "/tank"}) +
+			datasetLine(map[string]string{"name": "tank/home", "type": "filesystem", "used": "512", "mountpoint": "/tank/home"}),
+	}, "zfs", "list", "-rpH", "-t", "filesystem", "-o", dsPropListOptions)
+
+	filesystems, err := Filesystems(context.Background(), e, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filesystems) != 2 {
+		t.Fatalf("expected 2 filesystems, got %d", len(filesystems))
+	}
+	fs := filesystems[1]
+	if fs.Name != "tank/home" || fs.Used == nil || *fs.Used != 512 || fs.Mountpoint != "/tank/home" || fs.Origin != "" {
+		t.Errorf("unexpected dataset: %+v", fs)
+	}
+}
+
+func TestDatasetTypedProperties(t *testing.T) {
+	d := &Dataset{}
+	err := d.parseLine(dsPropList, splitLine(strings.TrimSuffix(datasetLine(map[string]string{
+		"name":          "tank@daily",
+		"type":          "snapshot",
+		"creation":      "1700000000",
+		"compressratio": "1.50x",
+		"used":          "4096",
+		"defer_destroy": "off",
+		"mounted":       "yes",
+		"quota":         "none",
+	}), "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d.Creation == nil || !d.Creation.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("unexpected creation: %v", d.Creation)
+	}
+	if d.Compressratio == nil || *d.Compressratio != 1.5 {
+		t.Errorf("unexpected compressratio: %v", d.Compressratio)
+	}
+	if d.Used == nil || *d.Used != 4096 {
+		t.Errorf("unexpected used: %v", d.Used)
+	}
+	if d.DeferDestroy == nil || *d.DeferDestroy || d.Mounted == nil || !*d.Mounted {
+		t.Errorf("unexpected booleans: %v %v", d.DeferDestroy, d.Mounted)
+	}
+	if d.Quota != nil || d.Available != nil {
+		t.Errorf("expected unset properties to be nil: %v %v", d.Quota, d.Available)
+	}
+
+	fields := datasetEvent("snapshot", d).Fields
+	if fields["used"] != uint64(4096) || fields["compressratio"] != 1.5 {
+		t.Errorf("unexpected event fields: %v", fields)
+	}
+	if _, ok := fields["available"]; ok {
+		t.Error("unset properties should not be published")
+	}
+}
+
+func TestSnapshotsError(t *testing.T) {
+	e := NewFakeExecutor()
+	e.On(FakeResult{Stderr: "cannot open 'nope': dataset does not exist", ExitCode: 1},
+		"zfs", "list", "-rpH", "-t", "snapshot", "-o", dsPropListOptions, "nope")
+
+	_, err := Snapshots(context.Background(), e, "nope", nil)
+	zerr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("expected *Error, got %v", err)
+	}
+	if !strings.Contains(zerr.Stderr, "does not exist") {
+		t.Errorf("unexpected stderr: %q", zerr.Stderr)
+	}
+}
+
+func TestGetDatasetProperties(t *testing.T) {
+	e := NewFakeExecutor()
+	e.On(FakeResult{Stdout: "tank/home\t512\t/tank/home\n"},
+		"zfs", "list", "-Hp", "-o", "name,used,mountpoint", "tank/home")
+
+	d, err := GetDataset(context.Background(), e, "tank/home", []string{"name", "used", "mountpoint"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Used == nil || *d.Used != 512 || d.Mountpoint != "/tank/home" {
+		t.Errorf("unexpected dataset: %+v", d)
+	}
+}
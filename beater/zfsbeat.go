@@ -1,7 +1,12 @@
 package beater
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/elastic/beats/libbeat/beat"
@@ -13,11 +18,62 @@ import (
 
 // Zfsbeat configuration.
 type Zfsbeat struct {
-	done   chan struct{}
-	config config.Config
-	client beat.Client
+	done      chan struct{}
+	ctx       context.Context
+	cancel    context.CancelFunc
+	config    config.Config
+	client    beat.Client
+	hosts     []*host
+	tempFiles []string
 }
 
+// host is a machine whose ZFS objects are collected, either the local one or
+// a remote one reached over SSH. Every host is collected on its own.
+type host struct {
+	name     string
+	address  string
+	executor Executor
+	sources  []*source
+	breakers map[string]*breaker
+
+	// breakersMu guards breakers, which the replication source of other
+	// hosts reads as well.
+	breakersMu sync.Mutex
+
+	// diffed holds the newest snapshot of every dataset of the diff source
+	// whose changes were published.
+	diffed map[string]string
+
+	// clones holds the origin of every clone, by pool, as of the previous
+	// collection of the lineage source.
+	clones map[string]map[string]string
+
+	// resumeTokens holds the receive resume tokens found, by pool and
+	// dataset, with the time they were first seen.
+	resumeTokens map[string]map[string]resumeToken
+
+	// keysUnavailable holds the filesystems and volumes whose key was
+	// unavailable on the previous successful collection, by dataset type and
+	// pool.
+	keysUnavailable map[string]map[string]bool
+
+	// properties are the dataset properties listed on the host, as
+	// supported by its ZFS release.
+	properties []string
+}
+
+// source is a kind of ZFS object collected on every period. Each source backs
+// off on its own when collecting it fails.
+type source struct {
+	name    string
+	collect collectFunc
+	backoff *backoff
+}
+
+// collectFunc collects a source of a host and hands every event to publish as
+// soon as it is built.
+type collectFunc func(ctx context.Context, h *host, publish func(beat.Event)) error
+
 // New creates an instance of zfsbeat.
 func New(b *beat.Beat, cfg *common.Config) (beat.Beater, error) {
 	c := config.DefaultConfig
@@ -25,11 +81,118 @@ func New(b *beat.Beat, cfg *common.Config) (beat.Beater, error) {
 		return nil, fmt.Errorf("Error reading config file: %v", err)
 	}
 
-	bt := &Zfsbeat{
+	bt := newZfsbeat(c)
+	if len(c.Hosts) == 0 {
+		bt.addHost("", "", NewExecutor(c))
+	}
+
+	for _, h := range c.Hosts {
+		keyFile := h.IdentityFile
+		if h.PrivateKey != "" {
+			var err error
+			keyFile, err = writePrivateKey(h.PrivateKey)
+			if err != nil {
+				bt.removeTempFiles()
+				return nil, fmt.Errorf("Error writing the private key of host %s: %v", h.Address, err)
+			}
+			bt.tempFiles = append(bt.tempFiles, keyFile)
+		}
+
+		name := h.Name
+		if name == "" {
+			name = h.Address
+		}
+		bt.addHost(name, h.Address, NewSSHExecutor(c, h, keyFile))
+	}
+	if len(c.Hosts) > 0 && bt.replicatesLocal() {
+		bt.addReplicationHost("", "", NewExecutor(c))
+	}
+
+	if err := bt.checkReplication(); err != nil {
+		bt.removeTempFiles()
+		return nil, err
+	}
+	return bt, nil
+}
+
+func newZfsbeat(c config.Config) *Zfsbeat {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Zfsbeat{
 		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
 		config: c,
 	}
-	return bt, nil
+}
+
+// newHost returns a host to collect with the given executor, without any
+// source yet. The local host has an empty name.
+func newHost(name, address string, e Executor) *host {
+	return &host{
+		name:     name,
+		address:  address,
+		executor: monitoredExecutor{e},
+	}
+}
+
+// addHost adds a host to collect with the given executor. The local host has
+// an empty name.
+func (bt *Zfsbeat) addHost(name, address string, e Executor) {
+	h := newHost(name, address, e)
+
+	if bt.config.SourceZpool {
+		bt.addSource(h, "zpool", bt.collectZpools)
+	}
+	if bt.config.SourceFilesystem {
+		bt.addSource(h, "filesystem", bt.collectFilesystems)
+	}
+	if bt.config.SourceSnapshot {
+		bt.addSource(h, "snapshot", bt.collectSnapshots)
+	}
+	if bt.config.SourceVolume {
+		bt.addSource(h, "volume", bt.collectVolumes)
+	}
+	if bt.config.SourceBookmark {
+		bt.addSource(h, "bookmark", bt.collectBookmarks)
+	}
+	if bt.config.SourceLineage {
+		bt.addSource(h, "lineage", bt.collectLineage)
+	}
+	if bt.config.SourceReceive {
+		bt.addSource(h, "receive", bt.collectReceives)
+	}
+	if len(bt.config.Space.Datasets) > 0 {
+		bt.addSource(h, "space", bt.collectSpace)
+	}
+	if len(bt.config.Diff.Datasets) > 0 {
+		bt.addSource(h, "diff", bt.collectDiffs)
+	}
+	if len(bt.config.Retention) > 0 {
+		bt.addSource(h, "retention", bt.collectRetention)
+	}
+	if len(bt.replications(h)) > 0 {
+		bt.addSource(h, "replication", bt.collectReplication)
+	}
+	bt.hosts = append(bt.hosts, h)
+}
+
+// addReplicationHost adds a host which only takes part in replication, such
+// as the local host when remote hosts are collected. Its pools are still
+// probed, for the circuit breakers of its datasets.
+func (bt *Zfsbeat) addReplicationHost(name, address string, e Executor) {
+	h := newHost(name, address, e)
+	if len(bt.replications(h)) > 0 {
+		bt.addSource(h, "replication", bt.collectReplication)
+	}
+	bt.hosts = append(bt.hosts, h)
+}
+
+func (bt *Zfsbeat) addSource(h *host, name string, collect collectFunc) {
+	h.sources = append(h.sources, &source{
+		name:    name,
+		collect: collect,
+		backoff: newBackoff(bt.config.Backoff.Init, bt.config.Backoff.Max),
+	})
 }
 
 // Run starts zfsbeat.
@@ -42,244 +205,497 @@ func (bt *Zfsbeat) Run(b *beat.Beat) error {
 		return err
 	}
 
+	var wg sync.WaitGroup
+	for _, h := range bt.hosts {
+		wg.Add(1)
+		go func(h *host) {
+			defer wg.Done()
+			bt.runHost(h)
+		}(h)
+	}
+	wg.Wait()
+	return nil
+}
+
+// runHost collects the sources of a host on every period until zfsbeat is
+// stopped.
+func (bt *Zfsbeat) runHost(h *host) {
+	bt.checkExecutor(h)
+	bt.discoverProperties(h)
+
 	ticker := time.NewTicker(bt.config.Period)
+	defer ticker.Stop()
 
 	for {
 		select {
 		case <-bt.done:
-			return nil
+			return
 		case <-ticker.C:
 		}
 
-		var events = []beat.Event{}
+		start := time.Now()
+		bt.probePools(h)
+		for _, s := range h.sources {
+			if !s.backoff.Ready(time.Now()) {
+				logp.Debug("zfsbeat", "Skipping source %s%s while backing off", s.name, h)
+				continue
+			}
+
+			m := getSourceMetrics(s.name)
+			count := 0
+			err := s.collect(bt.ctx, h, func(event beat.Event) {
+				bt.publish(h, event)
+				count++
+			})
+			m.events.Add(int64(count))
+			if bt.ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				m.errors.Inc()
+				bt.handleError(h, s, err)
+				continue
+			}
+			s.backoff.Reset()
+			logp.Debug("zfsbeat", "Published %d %s events%s", count, s.name, h)
+		}
+
+		elapsed := time.Since(start)
+		cycleDuration.Observe(elapsed)
+		if elapsed > bt.config.Period {
+			cycleOverruns.Inc()
+			logp.Warn("Collecting took %v%s, longer than the period of %v", elapsed, h, bt.config.Period)
+		}
+	}
+}
+
+// publish tags an event with the identity of a remote host and publishes it.
+func (bt *Zfsbeat) publish(h *host, event beat.Event) {
+	if h.name != "" {
+		event.Fields["remote.name"] = h.name
+		event.Fields["remote.address"] = h.address
+	}
+	bt.client.Publish(event)
+}
+
+// String returns the host for use in log messages, or an empty string for
+// the local host.
+func (h *host) String() string {
+	if h.name == "" {
+		return ""
+	}
+	return " on host " + h.name
+}
+
+// checkExecutor runs a cheap command at startup, to point the operator at a
+// broken binary path, command prefix or SSH setup before the first collection
+// fails.
+func (bt *Zfsbeat) checkExecutor(h *host) {
+	_, err := h.executor.Run(bt.ctx, "zpool", "list", "-H", "-o", "name")
+	if err == nil {
+		return
+	}
+
+	if h.name != "" {
+		logp.Err("Cannot run zpool%s: %v. Check that the host is reachable over SSH with the "+
+			"configured key and that its host key is known.", h, err)
+		return
+	}
+	if len(bt.config.CommandPrefix) > 0 {
+		logp.Err("Cannot run zpool through the command prefix %q: %v. Check that %q can run %q "+
+			"as the user of the beat without prompting, e.g. with NOPASSWD in sudoers.",
+			bt.config.CommandPrefix, err, bt.config.CommandPrefix[0], bt.config.ZpoolPath)
+		return
+	}
+	logp.Err("Cannot run zpool: %v. Check the zpool_path setting, run the beat as root "+
+		"or set command_prefix to a wrapper such as [\"sudo\", \"-n\"].", err)
+}
+
+// Stop stops zfsbeat and kills any command still running.
+func (bt *Zfsbeat) Stop() {
+	bt.cancel()
+	bt.client.Close()
+	close(bt.done)
+	bt.removeTempFiles()
+}
+
+func (bt *Zfsbeat) removeTempFiles() {
+	for _, f := range bt.tempFiles {
+		if err := os.Remove(f); err != nil {
+			logp.Warn("Error removing %s: %v", f, err)
+		}
+	}
+	bt.tempFiles = nil
+}
+
+// handleError publishes the failed collection of a source and decides when
+// the source is collected again, based on the type of the error.
+func (bt *Zfsbeat) handleError(h *host, s *source, err error) {
+	switch {
+	case errors.Is(err, ErrNotExist):
+		// A dataset or pool was destroyed while it was being listed, the
+		// next period will most likely succeed.
+		logp.Warn("Collecting %s%s raced with a destroyed dataset or pool: %v", s.name, h, err)
+	case errors.Is(err, ErrPoolSuspended):
+		// The circuit breaker of the pool skips it from now on, backing
+		// off would delay the healthy pools as well.
+		logp.Err("Collecting %s%s failed, %s: %v", s.name, h, remedy(err), err)
+	case remedy(err) != "":
+		logp.Critical("Collecting %s%s failed, %s: %v", s.name, h, remedy(err), err)
+		s.backoff.FailMax(time.Now())
+	default:
+		logp.Err("Error collecting %s%s: %v", s.name, h, err)
+		s.backoff.Fail(time.Now())
+	}
+	bt.publish(h, errorEvent(s.name, err))
+}
 
-		pools, err := ListZpools()
-		if err != nil {
-			panic(err)
+// remedy returns what the operator needs to do about errors which retrying
+// will not fix, or an empty string for all other errors.
+func remedy(err error) string {
+	switch {
+	case errors.Is(err, ErrBinaryNotFound):
+		return "install the ZFS utilities or add them to the PATH of the beat"
+	case errors.Is(err, ErrPermissionDenied):
+		var zerr *Error
+		if errors.As(err, &zerr) && strings.Contains(zerr.Stderr, "(publickey") {
+			return "authorize the SSH key of the beat on the remote host"
 		}
+		return "run the beat as root or delegate the permissions with `zfs allow`"
+	case errors.Is(err, ErrPoolSuspended):
+		return "bring the devices of the pool back and run `zpool clear`"
+	case errors.Is(err, ErrUnsupportedProperty):
+		return "the installed ZFS release does not support a collected property"
+	}
+	return ""
+}
 
-		snapshots, err := Snapshots("")
-		if err != nil {
-			panic(err)
+// errorEvent builds the event published when collecting a source failed.
+func errorEvent(source string, err error) beat.Event {
+	fields := common.MapStr{
+		"source":        source,
+		"error.message": err.Error(),
+	}
+	var zerr *Error
+	if errors.As(err, &zerr) {
+		fields["error.command"] = zerr.Debug
+		fields["error.stderr"] = zerr.Stderr
+		fields["error.exit_code"] = zerr.ExitCode
+		if zerr.Type != "" {
+			fields["error.type"] = string(zerr.Type)
 		}
+	}
+	if r := remedy(err); r != "" {
+		fields["error.remedy"] = r
+	}
+	return beat.Event{
+		Timestamp: time.Now(),
+		Fields:    fields,
+	}
+}
+
+// collectFilesystems publishes one event per ZFS filesystem, pool by pool.
+func (bt *Zfsbeat) collectFilesystems(ctx context.Context, h *host, publish func(beat.Event)) error {
+	return bt.collectDatasets(ctx, h, publish, DatasetFilesystem, nil)
+}
 
-		filesystems, err := Filesystems("")
-		if err != nil {
-			panic(err)
+// collectSnapshots publishes, pool by pool, one rollup event per dataset with
+// snapshots or, in events mode, one event per ZFS snapshot. Snapshots are
+// streamed, so memory use doesn't grow with their count. When holds are
+// collected, the held snapshots are remembered to list their holds afterwards.
+func (bt *Zfsbeat) collectSnapshots(ctx context.Context, h *host, publish func(beat.Event)) error {
+	var held []string
+	hold := func(d *Dataset) {
+		if bt.config.Holds.Enabled && d.Userrefs != nil && *d.Userrefs > 0 {
+			held = append(held, d.Name)
 		}
+	}
+
+	var err error
+	if bt.config.SnapshotMode == config.SnapshotModeEvents {
+		err = bt.collectDatasets(ctx, h, publish, DatasetSnapshot, func(d *Dataset, _ common.MapStr) {
+			hold(d)
+		})
+	} else {
+		err = bt.rollupSnapshots(ctx, h, publish, hold)
+	}
+	if err != nil || len(held) == 0 {
+		return err
+	}
+	return bt.collectHolds(ctx, h, publish, held)
+}
+
+// collectDatasets streams the datasets of type t, pool by pool, and publishes
+// one event per dataset with the dataset type as source. When extend isn't
+// nil, it adds source specific fields to every event.
+func (bt *Zfsbeat) collectDatasets(ctx context.Context, h *host, publish func(beat.Event), t string, extend func(d *Dataset, fields common.MapStr)) error {
+	return bt.collectPerPool(ctx, h, publish, func(pool string) error {
+		// A key_unavailable event is published when the key of a filesystem
+		// or volume becomes unavailable, rather than on every period while
+		// it stays so. The set is rebuilt on every successful listing, so
+		// destroyed datasets are dropped from it.
+		group := t + " " + pool
+		previous := h.keysUnavailable[group]
+		unavailable := map[string]bool{}
 
-		if bt.config.SourceFilesystem == true {
-			for _, filesystem := range filesystems {
-				event := beat.Event{
-					Timestamp: time.Now(),
-					Fields: common.MapStr{
-						"source":                "filesystem",
-						"name":                  filesystem.Name,
-						"available":             filesystem.Available,
-						"clones":                filesystem.Clones,
-						"compressratio":         filesystem.Compressratio,
-						"creation":              filesystem.Creation,
-						"defer.destroy":         filesystem.DeferDestroy,
-						"logical.referenced":    filesystem.Logicalreferenced,
-						"logical.used":          filesystem.Logicalused,
-						"mounted":               filesystem.Mounted,
-						"origin":                filesystem.Origin,
-						"ref.compressratio":     filesystem.Refcompressratio,
-						"referenced":            filesystem.Referenced,
-						"type":                  filesystem.Type,
-						"used":                  filesystem.Used,
-						"usedby.children":       filesystem.Usedbychildren,
-						"usedby.dataset":        filesystem.Usedbydataset,
-						"usedby.refreservation": filesystem.Usedbyrefreservation,
-						"usedby.snapshots":      filesystem.Usedbysnapshots,
-						"userrefs":              filesystem.Userrefs,
-						"written":               filesystem.Written,
-						"acl.inherit":           filesystem.Aclinherit,
-						"acl.type":              filesystem.Acltype,
-						"atime":                 filesystem.Atime,
-						"canmount":              filesystem.Canmount,
-						"casesensitivity":       filesystem.Casesensitivity,
-						"checksum":              filesystem.Checksum,
-						"compression":           filesystem.Compression,
-						"context":               filesystem.Context,
-						"copies":                filesystem.Copies,
-						"dedup":                 filesystem.Dedup,
-						"defcontext":            filesystem.Defcontext,
-						"devices":               filesystem.Devices,
-						"exec":                  filesystem.Exec,
-						"filesystem.count":      filesystem.FilesystemCount,
-						"filesystem.limit":      filesystem.FilesystemLimit,
-						"fscontext":             filesystem.Fscontext,
-						"logbias":               filesystem.Logbias,
-						"mlslabel":              filesystem.Mlslabel,
-						"mountpoint":            filesystem.Mountpoint,
-						"nbmand":                filesystem.Nbmand,
-						"normalization":         filesystem.Normalization,
-						"overlay":               filesystem.Overlay,
-						"primarycache":          filesystem.Primarycache,
-						"quota":                 filesystem.Quota,
-						"readonly":              filesystem.Readonly,
-						"recordsize":            filesystem.Recordsize,
-						"redundant.metadata":    filesystem.RedundantMetadata,
-						"ref.quota":             filesystem.Refquota,
-						"ref.reservation":       filesystem.Refreservation,
-						"relatime":              filesystem.Relatime,
-						"reservation":           filesystem.Reservation,
-						"rootcontext":           filesystem.Rootcontext,
-						"secondarycache":        filesystem.Secondarycache,
-						"setuid":                filesystem.Setuid,
-						"share.nfs":             filesystem.Sharenfs,
-						"share.smb":             filesystem.Sharesmb,
-						"snap.dev":              filesystem.Snapdev,
-						"snap.dir":              filesystem.Snapdir,
-						"snapshot.count":        filesystem.SnapshotCount,
-						"snapshot.limit":        filesystem.SnapshotLimit,
-						"sync":                  filesystem.Sync,
-						"utf8only":              filesystem.Utf8only,
-						"version":               filesystem.Version,
-						"vol.blocksize":         filesystem.Volblocksize,
-						"vol.size":              filesystem.Volsize,
-						"vscan":                 filesystem.Vscan,
-						"xattr":                 filesystem.Xattr,
-					},
+		collect := func(d *Dataset) error {
+			event := datasetEvent(t, d)
+			if extend != nil {
+				extend(d, event.Fields)
+			}
+			publish(event)
+			if t != DatasetSnapshot && d.Keystatus == KeyUnavailable {
+				unavailable[d.Name] = true
+				if !previous[d.Name] {
+					publish(keyUnavailableEvent(d))
 				}
-				events = append(events, event)
 			}
+			return nil
 		}
 
-		if bt.config.SourceSnapshot == true {
-			for _, snapshot := range snapshots {
-				event := beat.Event{
-					Timestamp: time.Now(),
-					Fields: common.MapStr{
-						"source":                "snapshot",
-						"name":                  snapshot.Name,
-						"available":             snapshot.Available,
-						"clones":                snapshot.Clones,
-						"compressratio":         snapshot.Compressratio,
-						"creation":              snapshot.Creation,
-						"defer.destroy":         snapshot.DeferDestroy,
-						"logical.referenced":    snapshot.Logicalreferenced,
-						"logical.used":          snapshot.Logicalused,
-						"mounted":               snapshot.Mounted,
-						"origin":                snapshot.Origin,
-						"ref.compressratio":     snapshot.Refcompressratio,
-						"referenced":            snapshot.Referenced,
-						"type":                  snapshot.Type,
-						"used":                  snapshot.Used,
-						"usedby.children":       snapshot.Usedbychildren,
-						"usedby.dataset":        snapshot.Usedbydataset,
-						"usedby.refreservation": snapshot.Usedbyrefreservation,
-						"usedby.snapshots":      snapshot.Usedbysnapshots,
-						"userrefs":              snapshot.Userrefs,
-						"written":               snapshot.Written,
-						"acl.inherit":           snapshot.Aclinherit,
-						"acl.type":              snapshot.Acltype,
-						"atime":                 snapshot.Atime,
-						"canmount":              snapshot.Canmount,
-						"casesensitivity":       snapshot.Casesensitivity,
-						"checksum":              snapshot.Checksum,
-						"compression":           snapshot.Compression,
-						"context":               snapshot.Context,
-						"copies":                snapshot.Copies,
-						"dedup":                 snapshot.Dedup,
-						"defcontext":            snapshot.Defcontext,
-						"devices":               snapshot.Devices,
-						"exec":                  snapshot.Exec,
-						"filesystem.count":      snapshot.FilesystemCount,
-						"filesystem.limit":      snapshot.FilesystemLimit,
-						"fscontext":             snapshot.Fscontext,
-						"logbias":               snapshot.Logbias,
-						"mlslabel":              snapshot.Mlslabel,
-						"mountpoint":            snapshot.Mountpoint,
-						"nbmand":                snapshot.Nbmand,
-						"normalization":         snapshot.Normalization,
-						"overlay":               snapshot.Overlay,
-						"primarycache":          snapshot.Primarycache,
-						"quota":                 snapshot.Quota,
-						"readonly":              snapshot.Readonly,
-						"recordsize":            snapshot.Recordsize,
-						"redundant.metadata":    snapshot.RedundantMetadata,
-						"ref.quota":             snapshot.Refquota,
-						"ref.reservation":       snapshot.Refreservation,
-						"relatime":              snapshot.Relatime,
-						"reservation":           snapshot.Reservation,
-						"rootcontext":           snapshot.Rootcontext,
-						"secondarycache":        snapshot.Secondarycache,
-						"setuid":                snapshot.Setuid,
-						"share.nfs":             snapshot.Sharenfs,
-						"share.smb":             snapshot.Sharesmb,
-						"snap.dev":              snapshot.Snapdev,
-						"snap.dir":              snapshot.Snapdir,
-						"snapshot.count":        snapshot.SnapshotCount,
-						"snapshot.limit":        snapshot.SnapshotLimit,
-						"sync":                  snapshot.Sync,
-						"utf8only":              snapshot.Utf8only,
-						"version":               snapshot.Version,
-						"vol.blocksize":         snapshot.Volblocksize,
-						"vol.size":              snapshot.Volsize,
-						"vscan":                 snapshot.Vscan,
-						"xattr":                 snapshot.Xattr,
-					},
-				}
-				events = append(events, event)
+		// User properties and property sources are only printed by zfs get.
+		var err error
+		c := bt.config
+		if !c.PropertySources && !c.UserProperties.Enabled {
+			err = streamByType(ctx, h.executor, t, pool, h.properties, collect)
+		} else {
+			var match func(prop string) bool
+			if c.UserProperties.Enabled {
+				match = bt.matchUserProperty
 			}
+			err = streamByGet(ctx, h.executor, t, pool, h.properties, c.PropertySources, match, collect)
+		}
+		if err != nil || t == DatasetSnapshot {
+			return err
 		}
 
-		if bt.config.SourceZpool == true {
-			for _, pool := range pools {
-				event := beat.Event{
-					Timestamp: time.Now(),
-					Fields: common.MapStr{
-						"source":                    "zpool",
-						"name":                      pool.Name,
-						"size":                      pool.Size,
-						"capacity":                  pool.Capacity,
-						"altroot":                   pool.Altroot,
-						"health":                    pool.Health,
-						"guid":                      pool.GUID,
-						"version":                   pool.Version,
-						"bootfs":                    pool.Bootfs,
-						"delegation":                pool.Delegation,
-						"autoreplace":               pool.Autoreplace,
-						"cachefile":                 pool.Cachefile,
-						"failmode":                  pool.Failmode,
-						"listsnapshots":             pool.Listsnapshots,
-						"autoexpand":                pool.Autoexpand,
-						"dedup_ditto":               pool.Dedupditto,
-						"dedup_ratio":               pool.Dedupratio,
-						"free":                      pool.Free,
-						"allocated":                 pool.Allocated,
-						"readonly":                  pool.Readonly,
-						"ashift":                    pool.Ashift,
-						"comment":                   pool.Comment,
-						"expandsize":                pool.Expandsize,
-						"freeing":                   pool.Freeing,
-						"fragmentation":             pool.Fragmentation,
-						"leaked":                    pool.Leaked,
-						"feature.asyncdestroy":      pool.FeatureAsyncDestroy,
-						"feature.emptybpobj":        pool.FeatureEmptyBpobj,
-						"feature.lz4compress":       pool.FeatureLz4Compress,
-						"feature.spacemaphistogram": pool.FeatureSpacemapHistogram,
-						"feature.enabledtxg":        pool.FeatureEnabledTxg,
-						"feature.holebirth":         pool.FeatureHoleBirth,
-						"feature.extensibledataset": pool.FeatureExtensibleDataset,
-						"feature.embeddeddata":      pool.FeatureEmbeddedData,
-						"feature.bookmarks":         pool.FeatureBookmarks,
-						"feature.filesystemlimits":  pool.FeatureFilesystemLimits,
-						"feature.largeblocks":       pool.FeatureLargeBlocks,
-					},
-				}
-				events = append(events, event)
-			}
+		if h.keysUnavailable == nil {
+			h.keysUnavailable = map[string]map[string]bool{}
 		}
-		bt.client.PublishAll(events)
-		logp.Info("Event sent")
+		h.keysUnavailable[group] = unavailable
+		return nil
+	})
+}
+
+// collectZpools publishes one event per ZFS pool.
+func (bt *Zfsbeat) collectZpools(ctx context.Context, h *host, publish func(beat.Event)) error {
+	pools, err := ListZpools(ctx, h.executor)
+	if err != nil {
+		return err
 	}
+
+	for _, pool := range pools {
+		publish(zpoolEvent(pool))
+	}
+	return nil
 }
 
-// Stop stops zfsbeat.
-func (bt *Zfsbeat) Stop() {
-	bt.client.Close()
-	close(bt.done)
+func datasetEvent(source string, d *Dataset) beat.Event {
+	fields := common.MapStr{
+		"source":                source,
+		"name":                  d.Name,
+		"available":             uintValue(d.Available),
+		"clones":                d.Clones,
+		"compressratio":         floatValue(d.Compressratio),
+		"creation":              timeValue(d.Creation),
+		"defer.destroy":         boolValue(d.DeferDestroy),
+		"logical.referenced":    uintValue(d.Logicalreferenced),
+		"logical.used":          uintValue(d.Logicalused),
+		"mounted":               boolValue(d.Mounted),
+		"origin":                d.Origin,
+		"ref.compressratio":     floatValue(d.Refcompressratio),
+		"referenced":            uintValue(d.Referenced),
+		"type":                  d.Type,
+		"used":                  uintValue(d.Used),
+		"usedby.children":       uintValue(d.Usedbychildren),
+		"usedby.dataset":        uintValue(d.Usedbydataset),
+		"usedby.refreservation": uintValue(d.Usedbyrefreservation),
+		"usedby.snapshots":      uintValue(d.Usedbysnapshots),
+		"userrefs":              uintValue(d.Userrefs),
+		"written":               uintValue(d.Written),
+		"guid":                  d.GUID,
+		"acl.inherit":           d.Aclinherit,
+		"acl.type":              d.Acltype,
+		"atime":                 boolValue(d.Atime),
+		"canmount":              d.Canmount,
+		"casesensitivity":       d.Casesensitivity,
+		"checksum":              d.Checksum,
+		"compression":           d.Compression,
+		"context":               d.Context,
+		"copies":                uintValue(d.Copies),
+		"dedup":                 d.Dedup,
+		"defcontext":            d.Defcontext,
+		"devices":               boolValue(d.Devices),
+		"encryption.algorithm":  d.Encryption,
+		"encryption.root":       d.Encryptionroot,
+		"exec":                  boolValue(d.Exec),
+		"filesystem.count":      uintValue(d.FilesystemCount),
+		"filesystem.limit":      uintValue(d.FilesystemLimit),
+		"fscontext":             d.Fscontext,
+		"key.format":            d.Keyformat,
+		"key.location":          d.Keylocation,
+		"key.status":            d.Keystatus,
+		"logbias":               d.Logbias,
+		"mlslabel":              d.Mlslabel,
+		"mountpoint":            d.Mountpoint,
+		"nbmand":                boolValue(d.Nbmand),
+		"normalization":         d.Normalization,
+		"overlay":               boolValue(d.Overlay),
+		"pbkdf2.iters":          uintValue(d.Pbkdf2iters),
+		"primarycache":          d.Primarycache,
+		"quota":                 uintValue(d.Quota),
+		"readonly":              boolValue(d.Readonly),
+		"receive.resume_token":  d.ReceiveResumeToken,
+		"recordsize":            uintValue(d.Recordsize),
+		"redundant.metadata":    d.RedundantMetadata,
+		"ref.quota":             uintValue(d.Refquota),
+		"ref.reservation":       uintValue(d.Refreservation),
+		"relatime":              boolValue(d.Relatime),
+		"reservation":           uintValue(d.Reservation),
+		"rootcontext":           d.Rootcontext,
+		"secondarycache":        d.Secondarycache,
+		"setuid":                boolValue(d.Setuid),
+		"share.nfs":             d.Sharenfs,
+		"share.smb":             d.Sharesmb,
+		"snap.dev":              d.Snapdev,
+		"snap.dir":              d.Snapdir,
+		"snapshot.count":        uintValue(d.SnapshotCount),
+		"snapshot.limit":        uintValue(d.SnapshotLimit),
+		"sync":                  d.Sync,
+		"utf8only":              boolValue(d.Utf8only),
+		"version":               uintValue(d.Version),
+		"vol.blocksize":         uintValue(d.Volblocksize),
+		"vol.size":              uintValue(d.Volsize),
+		"vscan":                 boolValue(d.Vscan),
+		"xattr":                 d.Xattr,
+	}
+	omitNil(fields)
+	for prop, value := range d.Properties {
+		fields["properties."+prop] = value
+	}
+	if len(d.UserProperties) > 0 {
+		userProps := common.MapStr{}
+		for prop, value := range d.UserProperties {
+			userProps[prop] = value
+		}
+		fields["zfs.dataset.user_properties"] = userProps
+	}
+	if len(d.Sources) > 0 {
+		fields.Update(propertySourceFields(d.Sources))
+	}
+
+	return beat.Event{
+		Timestamp: time.Now(),
+		Fields:    fields,
+	}
+}
+
+// keyUnavailableEvent reports an encrypted dataset whose key isn't loaded,
+// so it can't be mounted or read.
+func keyUnavailableEvent(d *Dataset) beat.Event {
+	fields := common.MapStr{
+		"source":               "key_unavailable",
+		"name":                 d.Name,
+		"type":                 d.Type,
+		"encryption.algorithm": d.Encryption,
+		"encryption.root":      d.Encryptionroot,
+		"key.format":           d.Keyformat,
+		"key.location":         d.Keylocation,
+		"key.status":           d.Keystatus,
+		"mounted":              boolValue(d.Mounted),
+	}
+	omitNil(fields)
+
+	return beat.Event{
+		Timestamp: time.Now(),
+		Fields:    fields,
+	}
+}
+
+// omitNil removes the properties which don't apply to a dataset from its
+// event fields.
+func omitNil(fields common.MapStr) {
+	for k, v := range fields {
+		if v == nil {
+			delete(fields, k)
+		}
+	}
+}
+
+// uintValue, floatValue, boolValue and timeValue dereference an optional
+// property value for an event, returning an untyped nil when it isn't set.
+func uintValue(v *uint64) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+func floatValue(v *float64) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+func boolValue(v *bool) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+func timeValue(v *time.Time) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+func zpoolEvent(z *Zpool) beat.Event {
+	fields := common.MapStr{
+		"source":                    "zpool",
+		"name":                      z.Name,
+		"size":                      z.Size,
+		"capacity":                  z.Capacity,
+		"altroot":                   z.Altroot,
+		"health":                    z.Health,
+		"guid":                      z.GUID,
+		"version":                   uintValue(z.Version),
+		"bootfs":                    z.Bootfs,
+		"delegation":                z.Delegation,
+		"autoreplace":               z.Autoreplace,
+		"cachefile":                 z.Cachefile,
+		"failmode":                  z.Failmode,
+		"listsnapshots":             z.Listsnapshots,
+		"autoexpand":                z.Autoexpand,
+		"dedup_ditto":               z.Dedupditto,
+		"dedup_ratio":               z.Dedupratio,
+		"free":                      z.Free,
+		"allocated":                 z.Allocated,
+		"readonly":                  boolValue(z.Readonly),
+		"ashift":                    z.Ashift,
+		"comment":                   z.Comment,
+		"expandsize":                z.Expandsize,
+		"freeing":                   z.Freeing,
+		"fragmentation":             z.Fragmentation,
+		"leaked":                    z.Leaked,
+		"feature.asyncdestroy":      z.FeatureAsyncDestroy,
+		"feature.emptybpobj":        z.FeatureEmptyBpobj,
+		"feature.lz4compress":       z.FeatureLz4Compress,
+		"feature.spacemaphistogram": z.FeatureSpacemapHistogram,
+		"feature.enabledtxg":        z.FeatureEnabledTxg,
+		"feature.holebirth":         z.FeatureHoleBirth,
+		"feature.extensibledataset": z.FeatureExtensibleDataset,
+		"feature.embeddeddata":      z.FeatureEmbeddedData,
+		"feature.bookmarks":         z.FeatureBookmarks,
+		"feature.filesystemlimits":  z.FeatureFilesystemLimits,
+		"feature.largeblocks":       z.FeatureLargeBlocks,
+	}
+	omitNil(fields)
+
+	return beat.Event{
+		Timestamp: time.Now(),
+		Fields:    fields,
+	}
 }
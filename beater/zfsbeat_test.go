@@ -0,0 +1,327 @@
+// +build !integration
+
+package beater
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/common"
+	pubtest "github.com/elastic/beats/libbeat/publisher/testing"
+
+	"github.com/maireanu/zfsbeat/config"
+)
+
+func TestRun(t *testing.T) {
+	e := NewFakeExecutor()
+	e.On(FakeResult{Stdout: "tank\n"}, "zpool", "list", "-H", "-o", "name")
+	e.On(FakeResult{Stdout: "tank\tsize\t1000\t-\n"}, "zpool", "get", "-Hp", "all")
+	e.On(FakeResult{Stdout: "tank@daily\t1546300800\t0\t0\t0\n"},
+		"zfs", "list", "-rpH", "-t", "snapshot", "-o", strings.Join(rollupPropList, ","), "tank")
+	e.On(FakeResult{Stdout: datasetLine(map[string]string{"name": "tank", "type": "filesystem"})},
+		"zfs", "list", "-rpH", "-t", "filesystem", "-o", dsPropListOptions, "tank")
+
+	c := config.DefaultConfig
+	c.Period = 10 * time.Millisecond
+	bt := newLocalZfsbeat(c, e)
+
+	client := pubtest.NewChanClient(10)
+	b := &beat.Beat{Publisher: pubtest.PublisherWithClient(client)}
+
+	errc := make(chan error, 1)
+	go func() { errc <- bt.Run(b) }()
+
+	sources := map[string]bool{}
+	for len(sources) < 3 {
+		select {
+		case event := <-client.Channel:
+			sources[event.Fields["source"].(string)] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for events, got %v", sources)
+		}
+	}
+
+	bt.Stop()
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunCommandTimeout(t *testing.T) {
+	e := NewFakeExecutor()
+	e.On(FakeResult{Stdout: "tank\n"}, "zpool", "list", "-H", "-o", "name")
+	e.On(FakeResult{Stdout: "tank\tsize\t1000\t-\n"}, "zpool", "get", "-Hp", "all")
+	e.On(FakeResult{Delay: time.Hour},
+		"zfs", "list", "-rpH", "-t", "snapshot", "-o", strings.Join(rollupPropList, ","), "tank")
+	e.On(FakeResult{Stdout: datasetLine(map[string]string{"name": "tank", "type": "filesystem"})},
+		"zfs", "list", "-rpH", "-t", "filesystem", "-o", dsPropListOptions, "tank")
+
+	c := config.DefaultConfig
+	c.Period = 10 * time.Millisecond
+	bt := newLocalZfsbeat(c, timeoutExecutor{e, 10 * time.Millisecond})
+
+	client := pubtest.NewChanClient(10)
+	b := &beat.Beat{Publisher: pubtest.PublisherWithClient(client)}
+
+	errc := make(chan error, 1)
+	go func() { errc <- bt.Run(b) }()
+
+	for {
+		select {
+		case event := <-client.Channel:
+			if _, ok := event.Fields["error.message"]; !ok {
+				continue
+			}
+			if event.Fields["source"] != "snapshot" || event.Fields["error.type"] != "timeout" {
+				t.Errorf("unexpected error event: %v", event.Fields)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the error event")
+		}
+		break
+	}
+
+	bt.Stop()
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunSourceIsolation(t *testing.T) {
+	e := NewFakeExecutor()
+	e.On(FakeResult{Stdout: "tank\n"}, "zpool", "list", "-H", "-o", "name")
+	e.On(FakeResult{Stderr: "internal error: Invalid argument", ExitCode: 1}, "zpool", "get", "-Hp", "all")
+	e.On(FakeResult{Stdout: datasetLine(map[string]string{"name": "tank", "type": "filesystem"})},
+		"zfs", "list", "-rpH", "-t", "filesystem", "-o", dsPropListOptions, "tank")
+
+	c := config.DefaultConfig
+	c.Period = 10 * time.Millisecond
+	c.SourceSnapshot = false
+	c.Backoff.Init = time.Hour
+	bt := newLocalZfsbeat(c, e)
+
+	client := pubtest.NewChanClient(10)
+	b := &beat.Beat{Publisher: pubtest.PublisherWithClient(client)}
+
+	errc := make(chan error, 1)
+	go func() { errc <- bt.Run(b) }()
+
+	var errors, filesystems int
+	for filesystems < 3 {
+		select {
+		case event := <-client.Channel:
+			switch {
+			case event.Fields["error.message"] != nil:
+				errors++
+				if event.Fields["source"] != "zpool" || event.Fields["error.command"] != "zpool get -Hp all" {
+					t.Errorf("unexpected error event: %v", event.Fields)
+				}
+			case event.Fields["source"] == "filesystem":
+				filesystems++
+			default:
+				t.Errorf("unexpected event: %v", event.Fields)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for events")
+		}
+	}
+
+	bt.Stop()
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	if errors != 1 {
+		t.Errorf("expected the failing source to back off after 1 error, got %d", errors)
+	}
+	for _, call := range e.Calls() {
+		if strings.Contains(call, "-t snapshot") {
+			t.Errorf("disabled snapshot source was collected: %s", call)
+		}
+	}
+}
+
+func TestRunHosts(t *testing.T) {
+	healthy := NewFakeExecutor()
+	healthy.On(FakeResult{Stdout: "tank\tsize\t1000\t-\n"}, "zpool", "get", "-Hp", "all")
+	unreachable := NewFakeExecutor()
+	unreachable.On(FakeResult{Stderr: "ssh: connect to host nas2 port 22: Connection refused\n", ExitCode: 255},
+		"zpool", "get", "-Hp", "all")
+
+	c := config.DefaultConfig
+	c.Period = 10 * time.Millisecond
+	c.SourceFilesystem = false
+	c.SourceSnapshot = false
+	bt := newZfsbeat(c)
+	bt.addHost("nas1", "10.0.0.1", healthy)
+	bt.addHost("nas2", "10.0.0.2", unreachable)
+
+	client := pubtest.NewChanClient(10)
+	b := &beat.Beat{Publisher: pubtest.PublisherWithClient(client)}
+
+	errc := make(chan error, 1)
+	go func() { errc <- bt.Run(b) }()
+
+	seen := map[string]common.MapStr{}
+	for len(seen) < 2 {
+		select {
+		case event := <-client.Channel:
+			seen[event.Fields["remote.name"].(string)] = event.Fields
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for events, got %v", seen)
+		}
+	}
+
+	bt.Stop()
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	if seen["nas1"]["name"] != "tank" || seen["nas1"]["remote.address"] != "10.0.0.1" {
+		t.Errorf("unexpected nas1 event: %v", seen["nas1"])
+	}
+	if seen["nas2"]["error.type"] != "host_unreachable" {
+		t.Errorf("unexpected nas2 event: %v", seen["nas2"])
+	}
+}
+
+func TestRunCircuitBreaker(t *testing.T) {
+	e := NewFakeExecutor()
+	e.On(FakeResult{Stdout: "tank\nbackup\n"}, "zpool", "list", "-H", "-o", "name")
+	e.On(FakeResult{Delay: time.Hour},
+		"zfs", "list", "-rpH", "-t", "filesystem", "-o", dsPropListOptions, "tank")
+	e.On(FakeResult{Stdout: datasetLine(map[string]string{"name": "backup", "type": "filesystem"})},
+		"zfs", "list", "-rpH", "-t", "filesystem", "-o", dsPropListOptions, "backup")
+	e.On(FakeResult{Stdout: "ONLINE\n"}, "zpool", "list", "-H", "-o", "health", "tank")
+
+	c := config.DefaultConfig
+	c.Period = 10 * time.Millisecond
+	c.SourceZpool = false
+	c.SourceSnapshot = false
+	c.Backoff.Init = time.Millisecond
+	c.Backoff.Max = time.Millisecond
+	c.CircuitBreaker.Threshold = 2
+	bt := newLocalZfsbeat(c, timeoutExecutor{e, 10 * time.Millisecond})
+
+	client := pubtest.NewChanClient(10)
+	b := &beat.Beat{Publisher: pubtest.PublisherWithClient(client)}
+
+	errc := make(chan error, 1)
+	go func() { errc <- bt.Run(b) }()
+
+	var sources []string
+	for len(sources) < 2 {
+		select {
+		case event := <-client.Channel:
+			switch source := event.Fields["source"]; source {
+			case "pool_unresponsive", "pool_recovered":
+				if event.Fields["name"] != "tank" {
+					t.Errorf("unexpected event: %v", event.Fields)
+				}
+				sources = append(sources, source.(string))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for circuit breaker events, got %v", sources)
+		}
+	}
+
+	bt.Stop()
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	if sources[0] != "pool_unresponsive" || sources[1] != "pool_recovered" {
+		t.Errorf("unexpected circuit breaker events: %v", sources)
+	}
+}
+
+// newLocalZfsbeat returns a Zfsbeat collecting the local host with e.
+func newLocalZfsbeat(c config.Config, e Executor) *Zfsbeat {
+	bt := newZfsbeat(c)
+	bt.addHost("", "", e)
+	return bt
+}
+
+// timeoutExecutor applies a per command timeout to another Executor.
+type timeoutExecutor struct {
+	Executor
+	timeout time.Duration
+}
+
+func (e timeoutExecutor) Run(ctx context.Context, name string, arg ...string) ([][]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+	return e.Executor.Run(ctx, name, arg...)
+}
+
+func (e timeoutExecutor) Stream(ctx context.Context, fn func(fields []string) error, name string, arg ...string) error {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+	return e.Executor.Stream(ctx, fn, name, arg...)
+}
+
+func TestCollectKeyUnavailable(t *testing.T) {
+	e := NewFakeExecutor()
+	e.On(FakeResult{Stdout: "tank\n"}, "zpool", "list", "-H", "-o", "name")
+	secretLine := datasetLine(map[string]string{"name": "tank/secret", "type": "filesystem", "encryption": "aes-256-gcm",
+		"encryptionroot": "tank/secret", "keyformat": "passphrase", "keylocation": "prompt", "keystatus": "unavailable",
+		"pbkdf2iters": "350000", "mounted": "no"})
+	e.On(FakeResult{Stdout: datasetLine(map[string]string{"name": "tank", "type": "filesystem", "encryption": "off", "keystatus": "-"}) +
+		secretLine},
+		"zfs", "list", "-rpH", "-t", "filesystem", "-o", dsPropListOptions, "tank")
+
+	bt := newLocalZfsbeat(config.DefaultConfig, e)
+
+	var events []beat.Event
+	err := bt.collectFilesystems(context.Background(), bt.hosts[0], func(event beat.Event) {
+		events = append(events, event)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+	secret := events[1].Fields
+	if secret["encryption.algorithm"] != "aes-256-gcm" || secret["key.status"] != "unavailable" || secret["pbkdf2.iters"] != uint64(350000) {
+		t.Errorf("unexpected event: %v", secret)
+	}
+	unavailable := events[2].Fields
+	if unavailable["source"] != "key_unavailable" || unavailable["name"] != "tank/secret" || unavailable["mounted"] != false {
+		t.Errorf("unexpected event: %v", unavailable)
+	}
+
+	// The key is still unavailable: only the dataset events are published.
+	events = nil
+	err = bt.collectFilesystems(context.Background(), bt.hosts[0], func(event beat.Event) {
+		events = append(events, event)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Errorf("expected 2 events, got %d", len(events))
+	}
+
+	// tank/secret is destroyed, then recreated with its key unavailable.
+	tank := datasetLine(map[string]string{"name": "tank", "type": "filesystem", "encryption": "off", "keystatus": "-"})
+	e.On(FakeResult{Stdout: tank}, "zfs", "list", "-rpH", "-t", "filesystem", "-o", dsPropListOptions, "tank")
+	if err := bt.collectFilesystems(context.Background(), bt.hosts[0], func(beat.Event) {}); err != nil {
+		t.Fatal(err)
+	}
+	e.On(FakeResult{Stdout: tank + secretLine}, "zfs", "list", "-rpH", "-t", "filesystem", "-o", dsPropListOptions, "tank")
+	events = nil
+	err = bt.collectFilesystems(context.Background(), bt.hosts[0], func(event beat.Event) {
+		events = append(events, event)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 || events[2].Fields["source"] != "key_unavailable" {
+		t.Errorf("expected a key_unavailable event for the recreated dataset, got %v", events)
+	}
+}
@@ -1,22 +1,29 @@
 package beater
 
 import (
+	"context"
+	"fmt"
 	"strconv"
 	"strings"
 )
 
 // ZFS zpool states, which can indicate if a pool is online, offline, degraded, etc
 const (
-	ZpoolOnline   = "ONLINE"
-	ZpoolDegraded = "DEGRADED"
-	ZpoolFaulted  = "FAULTED"
-	ZpoolOffline  = "OFFLINE"
-	ZpoolUnavail  = "UNAVAIL"
-	ZpoolRemoved  = "REMOVED"
+	ZpoolOnline    = "ONLINE"
+	ZpoolDegraded  = "DEGRADED"
+	ZpoolFaulted   = "FAULTED"
+	ZpoolOffline   = "OFFLINE"
+	ZpoolUnavail   = "UNAVAIL"
+	ZpoolRemoved   = "REMOVED"
+	ZpoolSuspended = "SUSPENDED"
 )
 
 // Zpool is a ZFS zpool.  A pool is a top-level structure in ZFS, and can
 // contain many descendent datasets.
+//
+// Readonly and Version are typed like the dataset properties of the same
+// name, as both end up in the same index. Version is nil on pools with
+// feature flags.
 type Zpool struct {
 	Name                     string
 	Size                     uint64
@@ -24,7 +31,7 @@ type Zpool struct {
 	Altroot                  string
 	Health                   string
 	GUID                     string
-	Version                  string
+	Version                  *uint64
 	Bootfs                   string
 	Delegation               string
 	Autoreplace              string
@@ -36,7 +43,7 @@ type Zpool struct {
 	Dedupratio               float64
 	Free                     uint64
 	Allocated                uint64
-	Readonly                 string
+	Readonly                 *bool
 	Ashift                   uint64
 	Comment                  string
 	Expandsize               uint64
@@ -56,59 +63,71 @@ type Zpool struct {
 	FeatureLargeBlocks       string
 }
 
-// List of Zpool properties to retrieve from zpool list command
-var zpoolPropList = []string{"name", "size", "capacity", "altroot", "health", "guid", "version", "bootfs", "delegation", "autoreplace", "cachefile", "failmode", "listsnapshots", "autoexpand", "dedupditto", "dedupratio", "free", "allocated", "readonly", "ashift", "comment", "expandsize", "freeing", "fragmentation", "leaked", "feature@async_destroy", "feature@empty_bpobj", "feature@lz4_compress", "feature@spacemap_histogram", "feature@enabled_txg", "feature@hole_birth", "feature@extensible_dataset", "feature@embedded_data", "feature@bookmarks", "feature@filesystem_limits", "feature@large_blocks"}
-var zpoolPropListOptions = strings.Join(zpoolPropList, ",")
-var zpoolArgs = []string{"get", "-p", zpoolPropListOptions}
-
-//var zpoolArgs = []string{"get", zpoolPropListOptions}
-
 // zpool is a helper function to wrap typical calls to zpool.
-func zpool(arg ...string) ([][]string, error) {
-	c := command{Command: "zpool"}
-	return c.Run(arg...)
+func zpool(ctx context.Context, e Executor, arg ...string) ([][]string, error) {
+	return e.Run(ctx, "zpool", arg...)
 }
 
 // ListZpools list all ZFS zpools accessible on the current system.
-func ListZpools() ([]*Zpool, error) {
-	args := []string{"list", "-Ho", "name"}
-	out, err := zpool(args...)
+func ListZpools(ctx context.Context, e Executor) ([]*Zpool, error) {
+	return getZpools(ctx, e)
+}
+
+// zpoolNames lists the names of all pools, without touching their datasets.
+func zpoolNames(ctx context.Context, e Executor) ([]string, error) {
+	out, err := zpool(ctx, e, "list", "-H", "-o", "name")
 	if err != nil {
 		return nil, err
 	}
 
-	var pools []*Zpool
-
+	names := make([]string, 0, len(out))
 	for _, line := range out {
-		z, err := GetZpool(line[0])
-		if err != nil {
-			return nil, err
+		if len(line) > 0 {
+			names = append(names, line[0])
 		}
-		pools = append(pools, z)
 	}
-	return pools, nil
+	return names, nil
 }
 
 // GetZpool retrieves a single ZFS zpool by name.
-func GetZpool(name string) (*Zpool, error) {
-	args := zpoolArgs
-	args = append(args, name)
-	out, err := zpool(args...)
+func GetZpool(ctx context.Context, e Executor, name string) (*Zpool, error) {
+	pools, err := getZpools(ctx, e, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(pools) == 0 {
+		return nil, newError(fmt.Errorf("no output"), 0, "zpool get -Hp all "+name, "cannot open '"+name+"': no such pool")
+	}
+	return pools[0], nil
+}
+
+// getZpools retrieves all properties of the named pools, or of all pools when
+// no name is given, with a single `zpool get` call. Every output line is a
+// name, property, value and source row.
+func getZpools(ctx context.Context, e Executor, names ...string) ([]*Zpool, error) {
+	args := append([]string{"get", "-Hp", "all"}, names...)
+	out, err := zpool(ctx, e, args...)
 	if err != nil {
 		return nil, err
 	}
 
-	// there is no -H
-	out = out[1:]
+	var pools []*Zpool
 
-	z := &Zpool{Name: name}
+	var z *Zpool
 	for _, line := range out {
+		if len(line) < 3 {
+			continue
+		}
+		if z == nil || z.Name != line[0] {
+			z = &Zpool{Name: line[0]}
+			pools = append(pools, z)
+		}
 		if err := z.parseLine(line); err != nil {
 			return nil, err
 		}
 	}
 
-	return z, nil
+	return pools, nil
 }
 
 func (z *Zpool) parseLine(line []string) error {
@@ -135,7 +154,7 @@ func (z *Zpool) parseLine(line []string) error {
 	case "guid":
 		setString(&z.GUID, val)
 	case "version":
-		setString(&z.Version, val)
+		err = setOptUint(&z.Version, val)
 	case "bootfs":
 		setString(&z.Bootfs, val)
 	case "delegation":
@@ -153,13 +172,13 @@ func (z *Zpool) parseLine(line []string) error {
 	case "dedupditto":
 		setString(&z.Dedupditto, val)
 	case "dedupratio":
-		z.Dedupratio, err = strconv.ParseFloat(val[:len(val)-1], 64)
+		z.Dedupratio, err = strconv.ParseFloat(strings.TrimSuffix(val, "x"), 64)
 	case "free":
 		err = setUint(&z.Free, val)
 	case "allocated":
 		err = setUint(&z.Allocated, val)
 	case "readonly":
-		setString(&z.Readonly, val)
+		err = setOptBool(&z.Readonly, val)
 	case "ashift":
 		err = setUint(&z.Ashift, val)
 	case "comment":
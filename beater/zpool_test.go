@@ -0,0 +1,66 @@
+// +build !integration
+
+package beater
+
+import (
+	"context"
+	"testing"
+)
+
+func TestListZpools(t *testing.T) {
+	e := NewFakeExecutor()
+	e.On(FakeResult{Stdout: "tank\tsize\t1000\t-\n" +
+		"tank\tcapacity\t42\t-\n" +
+		"tank\thealth\tONLINE\t-\n" +
+		"tank\tdedupratio\t1.00x\t-\n" +
+		"tank\tfragmentation\t7%\t-\n" +
+		"tank\treadonly\toff\t-\n" +
+		"tank\tversion\t-\tdefault\n" +
+		"backup\tsize\t2000\t-\n" +
+		"backup\tversion\t28\tlocal\n" +
+		"backup\thealth\tDEGRADED\t-\n",
+	}, "zpool", "get", "-Hp", "all")
+
+	pools, err := ListZpools(context.Background(), e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pools) != 2 {
+		t.Fatalf("expected 2 pools, got %d", len(pools))
+	}
+	if len(e.Calls()) != 1 {
+		t.Errorf("expected a single zpool call, got %v", e.Calls())
+	}
+	z := pools[0]
+	if z.Name != "tank" || z.Size != 1000 || z.Capacity != 42 || z.Health != ZpoolOnline || z.Dedupratio != 1 || z.Fragmentation != 7 {
+		t.Errorf("unexpected pool: %+v", z)
+	}
+	if z.Readonly == nil || *z.Readonly || z.Version != nil {
+		t.Errorf("unexpected readonly %v or version %v", z.Readonly, z.Version)
+	}
+	if v := pools[1].Version; v == nil || *v != 28 {
+		t.Errorf("expected version 28, got %v", v)
+	}
+
+	fields := zpoolEvent(z).Fields
+	if fields["readonly"] != false {
+		t.Errorf("expected readonly to be a bool, got %#v", fields["readonly"])
+	}
+	if _, ok := fields["version"]; ok {
+		t.Errorf("expected no version on a feature flag pool, got %#v", fields["version"])
+	}
+}
+
+func TestGetZpool(t *testing.T) {
+	e := NewFakeExecutor()
+	e.On(FakeResult{Stdout: "backup\tsize\t2000\t-\nbackup\thealth\tDEGRADED\t-\n"},
+		"zpool", "get", "-Hp", "all", "backup")
+
+	z, err := GetZpool(context.Background(), e, "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if z.Name != "backup" || z.Size != 2000 || z.Health != ZpoolDegraded {
+		t.Errorf("unexpected pool: %+v", z)
+	}
+}
@@ -0,0 +1,88 @@
+package beater
+
+import (
+	"context"
+	"path"
+	"path/filepath"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// zvolDir holds the device paths of the volumes.
+const zvolDir = "/dev/zvol"
+
+// ZvolPath returns the device path of a volume, such as /dev/zvol/tank/vm1.
+func ZvolPath(name string) string {
+	return path.Join(zvolDir, name)
+}
+
+// ZvolDevices returns the device names, such as zd0, of the given /dev/zvol
+// paths of a host, keyed by path. On Linux those paths are symlinks created
+// by udev to the /dev/zdN devices; paths which don't link anywhere else are
+// left out. The links are resolved with `readlink -m`, which prints one line
+// per path even when it is missing.
+func ZvolDevices(ctx context.Context, e Executor, paths []string) (map[string]string, error) {
+	devices := map[string]string{}
+	i := 0
+	err := e.Stream(ctx, func(line []string) error {
+		if i < len(paths) && len(line) > 0 && line[0] != paths[i] {
+			devices[paths[i]] = path.Base(line[0])
+		}
+		i++
+		return nil
+	}, "readlink", append([]string{"-m", "--"}, paths...)...)
+	if err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// localZvolDevices is ZvolDevices for the local host, which resolves the
+// links itself.
+func localZvolDevices(paths []string) map[string]string {
+	devices := map[string]string{}
+	for _, p := range paths {
+		if target, err := filepath.EvalSymlinks(p); err == nil && target != p {
+			devices[p] = filepath.Base(target)
+		}
+	}
+	return devices
+}
+
+// collectVolumes publishes one event per ZFS volume, pool by pool, with the
+// device it is exposed as. Volumes are few, so their events are held back to
+// resolve all their devices at once.
+func (bt *Zfsbeat) collectVolumes(ctx context.Context, h *host, publish func(beat.Event)) error {
+	var events []beat.Event
+	var paths []string
+	err := bt.collectDatasets(ctx, h, func(event beat.Event) {
+		events = append(events, event)
+	}, DatasetVolume, func(d *Dataset, fields common.MapStr) {
+		p := ZvolPath(d.Name)
+		fields["device.path"] = p
+		paths = append(paths, p)
+	})
+
+	var devices map[string]string
+	if h.name == "" {
+		devices = localZvolDevices(paths)
+	} else if len(paths) > 0 {
+		var derr error
+		devices, derr = ZvolDevices(ctx, h.executor, paths)
+		if derr != nil {
+			logp.Warn("Cannot resolve the devices of the volumes%s, publishing them without device.name: %v", h, derr)
+		}
+	}
+
+	for _, event := range events {
+		if p, ok := event.Fields["device.path"].(string); ok {
+			if device, ok := devices[p]; ok {
+				event.Fields["device.name"] = device
+			}
+		}
+		publish(event)
+	}
+	return err
+}
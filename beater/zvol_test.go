@@ -0,0 +1,74 @@
+// +build !integration
+
+package beater
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/beat"
+
+	"github.com/maireanu/zfsbeat/config"
+)
+
+func TestCollectVolumes(t *testing.T) {
+	e := NewFakeExecutor()
+	e.On(FakeResult{Stdout: "tank\n"}, "zpool", "list", "-H", "-o", "name")
+	e.On(FakeResult{Stdout: "/dev/zd16\n/dev/zvol/tank/vm2\n"},
+		"readlink", "-m", "--", "/dev/zvol/tank/vm1", "/dev/zvol/tank/vm2")
+	e.On(FakeResult{Stdout: datasetLine(map[string]string{"name": "tank/vm1", "type": "volume", "volsize": "10737418240"}) +
+		datasetLine(map[string]string{"name": "tank/vm2", "type": "volume"})},
+		"zfs", "list", "-rpH", "-t", "volume", "-o", dsPropListOptions, "tank")
+
+	c := config.DefaultConfig
+	c.SourceVolume = true
+	bt := newZfsbeat(c)
+	bt.addHost("nas1", "nas1.example.com", e)
+
+	var events []beat.Event
+	err := bt.collectVolumes(context.Background(), bt.hosts[0], func(event beat.Event) {
+		events = append(events, event)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	vm1, vm2 := events[0].Fields, events[1].Fields
+	if vm1["source"] != "volume" || vm1["vol.size"] != uint64(10737418240) {
+		t.Errorf("unexpected event: %v", vm1)
+	}
+	if vm1["device.path"] != "/dev/zvol/tank/vm1" || vm1["device.name"] != "zd16" {
+		t.Errorf("unexpected device of tank/vm1: %v", vm1)
+	}
+	if _, ok := vm2["device.name"]; ok || vm2["device.path"] != "/dev/zvol/tank/vm2" {
+		t.Errorf("unexpected device of tank/vm2: %v", vm2)
+	}
+}
+
+func TestLocalZvolDevices(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zvol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	device := filepath.Join(dir, "zd16")
+	if err := ioutil.WriteFile(device, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "vm1")
+	if err := os.Symlink("zd16", link); err != nil {
+		t.Fatal(err)
+	}
+
+	devices := localZvolDevices([]string{link, filepath.Join(dir, "vm2")})
+	if len(devices) != 1 || devices[link] != "zd16" {
+		t.Errorf("unexpected devices: %v", devices)
+	}
+}
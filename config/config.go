@@ -3,14 +3,189 @@
 
 package config
 
-import "time"
+import (
+	"fmt"
+	"path"
+	"time"
+)
 
 //Config for period
 type Config struct {
-	Period           time.Duration `config:"period"`
-	SourceZpool      bool          `config:"source_zpool"`
-	SourceFilesystem bool          `config:"source_filesystem"`
-	SourceSnapshot   bool          `config:"source_snapshot"`
+	Period           time.Duration  `config:"period"`
+	SourceZpool      bool           `config:"source_zpool"`
+	SourceFilesystem bool           `config:"source_filesystem"`
+	SourceSnapshot   bool           `config:"source_snapshot"`
+	SnapshotMode     string         `config:"snapshot_mode"`
+	SourceVolume     bool           `config:"source_volume"`
+	SourceBookmark   bool           `config:"source_bookmark"`
+	SourceLineage    bool           `config:"source_lineage"`
+	SourceReceive    bool           `config:"source_receive"`
+	CommandTimeout   time.Duration  `config:"command_timeout"`
+	CommandPrefix    []string       `config:"command_prefix"`
+	ZfsPath          string         `config:"zfs_path"`
+	ZpoolPath        string         `config:"zpool_path"`
+	Backoff          Backoff        `config:"backoff"`
+	SSHPath          string         `config:"ssh_path"`
+	Hosts            []Host         `config:"hosts"`
+	CircuitBreaker   CircuitBreaker `config:"circuit_breaker"`
+	UserProperties   UserProperties `config:"user_properties"`
+	PropertySources  bool           `config:"property_sources"`
+	Space            Space          `config:"space"`
+	Holds            Holds          `config:"holds"`
+	Diff             Diff           `config:"diff"`
+	Retention        []Retention    `config:"retention"`
+	Replication      []Replication  `config:"replication"`
+}
+
+// Snapshot modes: one rollup event per dataset, or one event per snapshot.
+const (
+	SnapshotModeRollup = "rollup"
+	SnapshotModeEvents = "events"
+)
+
+// Validate checks the snapshot mode.
+func (c *Config) Validate() error {
+	switch c.SnapshotMode {
+	case SnapshotModeRollup, SnapshotModeEvents:
+		return nil
+	}
+	return fmt.Errorf("invalid snapshot_mode %q, expected %s or %s", c.SnapshotMode, SnapshotModeRollup, SnapshotModeEvents)
+}
+
+// UserProperties configures the collection of ZFS user properties
+// (module:property) of filesystems and snapshots. Include and Exclude are
+// glob patterns on the property name, such as "com.sun:*"; all properties are
+// included when Include is empty.
+type UserProperties struct {
+	Enabled bool     `config:"enabled"`
+	Include []string `config:"include"`
+	Exclude []string `config:"exclude"`
+}
+
+// Validate checks the syntax of the include and exclude patterns.
+func (u *UserProperties) Validate() error {
+	for _, pattern := range append(u.Include, u.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid user property pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Space configures the space accounting source, which reports the space and
+// objects used by every user, group or project of Datasets, along with their
+// quotas. Types selects the kinds of principals: user, group and project.
+// Numeric user and group IDs are resolved to names on the host when
+// ResolveNames is set; projects only have numeric IDs.
+type Space struct {
+	Datasets     []string `config:"datasets"`
+	Types        []string `config:"types"`
+	ResolveNames bool     `config:"resolve_names"`
+}
+
+// Validate checks the kinds of principals.
+func (s *Space) Validate() error {
+	for _, t := range s.Types {
+		switch t {
+		case "user", "group", "project":
+		default:
+			return fmt.Errorf("invalid space accounting type %q, expected user, group or project", t)
+		}
+	}
+	return nil
+}
+
+// Holds configures the collection of the holds of snapshots with user
+// references. Holds older than MaxAge are flagged as stale, unless it is zero.
+type Holds struct {
+	Enabled bool          `config:"enabled"`
+	MaxAge  time.Duration `config:"max_age"`
+}
+
+// Diff configures the diff source, which summarises the changes between the
+// two newest snapshots of Datasets whenever a new snapshot is taken. TopPaths
+// is the number of directories with the most changes to report.
+type Diff struct {
+	Datasets []string `config:"datasets"`
+	TopPaths int      `config:"top_paths" validate:"min=0"`
+}
+
+// Retention is a snapshot retention policy. The datasets whose name matches
+// one of the Datasets glob patterns are expected to have a snapshot whose name
+// starts with Prefix every Interval, and no more than Keep of them. A snapshot
+// may be up to Grace late, a quarter of Interval when unset.
+type Retention struct {
+	Name     string        `config:"name"`
+	Datasets []string      `config:"datasets" validate:"required"`
+	Prefix   string        `config:"prefix"`
+	Interval time.Duration `config:"interval" validate:"required"`
+	Keep     int           `config:"keep" validate:"min=0"`
+	Grace    time.Duration `config:"grace"`
+}
+
+// Validate checks the syntax of the dataset patterns.
+func (r *Retention) Validate() error {
+	if r.Interval <= 0 {
+		return fmt.Errorf("retention interval must be positive, got %v", r.Interval)
+	}
+	for _, pattern := range r.Datasets {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid retention dataset pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Replication pairs a source dataset with the target dataset it is replicated
+// to with zfs send and zfs recv. Their snapshots are compared by GUID to report
+// the replication lag.
+type Replication struct {
+	Name   string          `config:"name"`
+	Source ReplicationSide `config:"source"`
+	Target ReplicationSide `config:"target"`
+}
+
+// ReplicationSide is a dataset on a host, given by the name of one of Hosts,
+// or on the local host when Host is empty.
+type ReplicationSide struct {
+	Host    string `config:"host"`
+	Dataset string `config:"dataset" validate:"required"`
+}
+
+// CircuitBreaker configures when a pool stops being collected. After Threshold
+// consecutive command timeouts its datasets are skipped, until a health probe
+// of the pool succeeds within ProbeTimeout.
+type CircuitBreaker struct {
+	Threshold    int           `config:"threshold" validate:"min=1"`
+	ProbeTimeout time.Duration `config:"probe_timeout"`
+}
+
+// Validate checks that health probes get time to answer.
+func (c *CircuitBreaker) Validate() error {
+	if c.ProbeTimeout <= 0 {
+		return fmt.Errorf("circuit_breaker.probe_timeout must be positive, got %v", c.ProbeTimeout)
+	}
+	return nil
+}
+
+// Host is a remote host whose zfs and zpool commands are run over SSH, with
+// key based authentication. PrivateKey holds the key itself, usually as a
+// reference to the keystore, and takes precedence over IdentityFile.
+type Host struct {
+	Name           string `config:"name"`
+	Address        string `config:"address" validate:"required"`
+	Port           int    `config:"port"`
+	User           string `config:"user"`
+	IdentityFile   string `config:"identity_file"`
+	PrivateKey     string `config:"private_key"`
+	KnownHostsFile string `config:"known_hosts_file"`
+}
+
+// Backoff configures how long a failing source is skipped. The delay starts at
+// Init and doubles with every consecutive failure, up to Max.
+type Backoff struct {
+	Init time.Duration `config:"init"`
+	Max  time.Duration `config:"max"`
 }
 
 //DefaultConfig configuration for period
@@ -19,4 +194,23 @@ var DefaultConfig = Config{
 	SourceZpool:      true,
 	SourceFilesystem: true,
 	SourceSnapshot:   true,
+	SnapshotMode:     SnapshotModeRollup,
+	CommandTimeout:   30 * time.Second,
+	ZfsPath:          "zfs",
+	ZpoolPath:        "zpool",
+	SSHPath:          "ssh",
+	CircuitBreaker: CircuitBreaker{
+		Threshold:    3,
+		ProbeTimeout: 5 * time.Second,
+	},
+	Backoff: Backoff{
+		Init: 1 * time.Second,
+		Max:  60 * time.Second,
+	},
+	Space: Space{
+		Types: []string{"user", "group"},
+	},
+	Diff: Diff{
+		TopPaths: 10,
+	},
 }